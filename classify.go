@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"golang.org/x/sys/windows"
+)
+
+var CLASSIFY_INTERVAL = 30 * time.Second
+var CLASSIFY_PAGE = 200
+var CLASSIFY_SNIFF_SIZE = 512
+
+var CLASSIFY_CATEGORIES = []string{
+	"document", "image", "audio", "video", "archive", "code", "executable", "other",
+}
+
+// classifyMagic are signatures http.DetectContentType does not know.
+var classifyMagic = []struct {
+	magic []byte
+	mime  string
+}{
+	{[]byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{[]byte("\x7fELF"), "application/x-elf"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{[]byte("Rar!\x1a\x07"), "application/vnd.rar"},
+	{[]byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{[]byte("BZh"), "application/x-bzip2"},
+	{[]byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{[]byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "application/x-ole-storage"},
+}
+
+// classifyExt decides the category when the content only tells a container,
+// such as zip for docx or plain text for source code.
+var classifyExt = map[string]string{
+	".doc": "document", ".docx": "document", ".xls": "document", ".xlsx": "document",
+	".ppt": "document", ".pptx": "document", ".odt": "document", ".ods": "document",
+	".odp": "document", ".pdf": "document", ".rtf": "document", ".txt": "document",
+	".md": "document", ".csv": "document", ".epub": "document", ".wps": "document",
+
+	".go": "code", ".c": "code", ".h": "code", ".cpp": "code", ".hpp": "code", ".cc": "code",
+	".cs": "code", ".java": "code", ".kt": "code", ".py": "code", ".js": "code", ".ts": "code",
+	".jsx": "code", ".tsx": "code", ".rs": "code", ".rb": "code", ".php": "code", ".lua": "code",
+	".sh": "code", ".bat": "code", ".cmd": "code", ".ps1": "code", ".sql": "code", ".html": "code",
+	".htm": "code", ".css": "code", ".json": "code", ".xml": "code", ".yaml": "code", ".yml": "code",
+	".toml": "code", ".ini": "code", ".swift": "code", ".m": "code", ".vue": "code",
+
+	".zip": "archive", ".rar": "archive", ".7z": "archive", ".gz": "archive", ".tgz": "archive",
+	".tar": "archive", ".bz2": "archive", ".xz": "archive", ".zst": "archive", ".iso": "archive",
+	".cab": "archive",
+
+	".exe": "executable", ".dll": "executable", ".msi": "executable", ".sys": "executable",
+	".com": "executable", ".scr": "executable", ".so": "executable",
+}
+
+func classifyGeneric(mimeType string) bool {
+	switch mimeType {
+	case "", "application/octet-stream", "text/plain", "application/zip", "application/x-ole-storage":
+		return true
+	}
+	return false
+}
+
+// classifyHead reads the first bytes of path, nil for cloud placeholders
+// and offline files which a read would recall. Reads through the handle do
+// not update the last access time when the attributes may be written.
+func classifyHead(path string) ([]byte, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	attr, err := windows.GetFileAttributes(name)
+	if err != nil {
+		return nil, err
+	}
+	if attr&(windows.FILE_ATTRIBUTE_RECALL_ON_DATA_ACCESS|
+		windows.FILE_ATTRIBUTE_RECALL_ON_OPEN|windows.FILE_ATTRIBUTE_OFFLINE) != 0 {
+		return nil, nil
+	}
+
+	share := uint32(FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE)
+	handle, err := windows.CreateFile(name, windows.GENERIC_READ|windows.FILE_WRITE_ATTRIBUTES,
+		share, nil, OPEN_EXISTING, FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err == nil {
+		keep := windows.Filetime{LowDateTime: 0xFFFFFFFF, HighDateTime: 0xFFFFFFFF}
+		windows.SetFileTime(handle, nil, &keep, nil)
+	} else {
+		handle, err = windows.CreateFile(name, windows.GENERIC_READ,
+			share, nil, OPEN_EXISTING, FILE_FLAG_BACKUP_SEMANTICS, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer windows.CloseHandle(handle)
+
+	head := make([]byte, CLASSIFY_SNIFF_SIZE)
+	var n uint32
+	err = windows.ReadFile(handle, head, &n, nil)
+	if err != nil {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// Classify returns the MIME type of the file from its first bytes, the
+// extension decides when the content is unknown or a generic container.
+// Both are empty when the file can not be read, such as locked or denied,
+// so that it stays unclassified and is tried again by the next run.
+func Classify(path string, ext string) (string, string) {
+	ext = strings.ToLower(ext)
+
+	head, err := classifyHead(path)
+	if err != nil {
+		logs.Debug("classify %s failed, %s", path, err.Error())
+		return "", ""
+	}
+
+	mimeType := ""
+	for _, v := range classifyMagic {
+		if bytes.HasPrefix(head, v.magic) {
+			mimeType = v.mime
+			break
+		}
+	}
+	if mimeType == "" && len(head) > 0 {
+		mimeType = http.DetectContentType(head)
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+
+	if classifyGeneric(mimeType) {
+		if v, _, _ := strings.Cut(mime.TypeByExtension(ext), ";"); v != "" {
+			mimeType = v
+		}
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	return mimeType, classifyCategory(mimeType, ext)
+}
+
+func classifyCategory(mimeType string, ext string) string {
+	category, known := classifyExt[ext]
+	if known && (classifyGeneric(mimeType) || strings.HasPrefix(mimeType, "text/")) {
+		return category
+	}
+
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case mimeType == "application/pdf", mimeType == "application/msword", mimeType == "application/rtf",
+		mimeType == "application/epub+zip", mimeType == "text/csv", mimeType == "text/markdown",
+		strings.HasPrefix(mimeType, "application/vnd.ms-"),
+		strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument."):
+		return "document"
+	case mimeType == "application/zip", mimeType == "application/gzip", mimeType == "application/x-gzip",
+		mimeType == "application/x-tar", mimeType == "application/x-7z-compressed",
+		mimeType == "application/vnd.rar", mimeType == "application/x-rar-compressed",
+		mimeType == "application/x-bzip2", mimeType == "application/x-xz", mimeType == "application/zstd":
+		return "archive"
+	case mimeType == "application/vnd.microsoft.portable-executable", mimeType == "application/x-elf",
+		mimeType == "application/x-msdownload", mimeType == "application/x-msdos-program":
+		return "executable"
+	case mimeType == "text/html", mimeType == "text/xml", mimeType == "application/xml",
+		mimeType == "application/json", mimeType == "text/javascript", mimeType == "application/javascript",
+		strings.HasPrefix(mimeType, "text/x-"):
+		return "code"
+	}
+
+	if known {
+		return category
+	}
+	if strings.HasPrefix(mimeType, "text/") {
+		return "document"
+	}
+	return "other"
+}
+
+// ClassifyInit classifies the files of the index in the background. A
+// classify job is started every CLASSIFY_INTERVAL while no other job runs
+// and files are waiting, so scans are not slowed down.
+func (s *Server) ClassifyInit() {
+	if s.sql.ReadOnly() {
+		return
+	}
+
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+
+		ticker := time.NewTicker(CLASSIFY_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if s.jobs.Busy("classify") || !s.sql.ClassifyPending() {
+				continue
+			}
+			_, err := s.jobs.Start(s.ctx, "classify", "", s.classifyJob)
+			if err != nil {
+				logs.Warning("classify job start failed, %s", err.Error())
+			}
+		}
+	}()
+}
+
+// classifyJob sniffs the unclassified files page by page, it stops as soon
+// as another job runs and is resumed by the next tick.
+func (s *Server) classifyJob(ctx context.Context, progress Progress) (string, error) {
+	total := s.sql.ClassifyCount()
+	count := 0
+	failed := 0
+	after := int64(0)
+
+	for ctx.Err() == nil {
+		if s.jobs.Busy("classify") {
+			return fmt.Sprintf("%d classified, %d unreadable, paused", count-failed, failed), nil
+		}
+
+		files, err := s.sql.ClassifyPage(after, CLASSIFY_PAGE)
+		if err != nil {
+			return "", err
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for i := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			files[i].Mime, files[i].Category = Classify(files[i].Path, files[i].Ext)
+			if files[i].Mime == "" {
+				failed++
+			}
+			count++
+			progress.Update(int64(count), total, files[i].Path)
+		}
+
+		err = s.sql.ClassifySet(files)
+		if err != nil {
+			return "", err
+		}
+		after = files[len(files)-1].ID
+	}
+
+	return fmt.Sprintf("%d classified, %d unreadable", count-failed, failed), nil
+}
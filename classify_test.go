@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		mime     string // not checked when empty, the registry may map the extension
+		category string
+	}{
+		{"a.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png", "image"},
+		{"a.bin", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png", "image"},
+		{"a.exe", "MZ\x90\x00\x03\x00\x00\x00", "application/vnd.microsoft.portable-executable", "executable"},
+		{"a.dat", "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n", "application/pdf", "document"},
+		{"a.gz", "\x1f\x8b\x08\x00\x00\x00\x00\x00", "application/x-gzip", "archive"},
+		{"a.7z", "7z\xbc\xaf\x27\x1c\x00\x04", "application/x-7z-compressed", "archive"},
+		{"a.docx", "PK\x03\x04\x14\x00\x06\x00", "", "document"},
+		{"a.zip", "PK\x03\x04\x14\x00\x06\x00", "", "archive"},
+		{"main.go", "package main\n\nfunc main() {}\n", "", "code"},
+		{"notes.txt", "<html><body>notes</body></html>", "text/html", "document"},
+		{"page.html", "<html><body>page</body></html>", "text/html", "code"},
+		{"a.PNG", "", "image/png", "image"},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		err := os.WriteFile(path, []byte(tt.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mime, category := Classify(path, filepath.Ext(path))
+		if (tt.mime != "" && mime != tt.mime) || category != tt.category {
+			t.Errorf("Classify(%s) = %s %s, want %s %s", tt.name, mime, category, tt.mime, tt.category)
+		}
+	}
+
+	mime, category := Classify(filepath.Join(dir, "missing.pdf"), ".pdf")
+	if mime != "" || category != "" {
+		t.Errorf("Classify(missing.pdf) = %s %s, want none to try again", mime, category)
+	}
+}
+
+func TestClassifyCategory(t *testing.T) {
+	tests := []struct {
+		mime     string
+		ext      string
+		category string
+	}{
+		{"image/jpeg", ".jpg", "image"},
+		{"image/png", ".txt", "image"},
+		{"audio/mpeg", ".mp3", "audio"},
+		{"video/mp4", ".mp4", "video"},
+		{"application/pdf", "", "document"},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx", "document"},
+		{"application/vnd.ms-excel", ".xls", "document"},
+		{"application/vnd.oasis.opendocument.text", ".odt", "document"},
+		{"application/zip", ".docx", "document"},
+		{"application/zip", ".zip", "archive"},
+		{"application/zip", ".jar", "archive"},
+		{"application/x-ole-storage", ".doc", "document"},
+		{"application/x-ole-storage", ".bin", "other"},
+		{"application/x-gzip", ".tgz", "archive"},
+		{"application/vnd.microsoft.portable-executable", ".dll", "executable"},
+		{"application/vnd.microsoft.portable-executable", ".txt", "executable"},
+		{"application/x-elf", "", "executable"},
+		{"text/plain", ".go", "code"},
+		{"text/plain", ".md", "document"},
+		{"text/plain", "", "document"},
+		{"text/html", ".html", "code"},
+		{"text/html", ".txt", "document"},
+		{"text/x-python", ".py", "code"},
+		{"application/json", "", "code"},
+		{"application/octet-stream", ".exe", "executable"},
+		{"application/octet-stream", ".bin", "other"},
+		{"application/octet-stream", "", "other"},
+		{"font/woff2", ".woff2", "other"},
+	}
+
+	for _, tt := range tests {
+		category := classifyCategory(tt.mime, tt.ext)
+		if category != tt.category {
+			t.Errorf("classifyCategory(%s, %s) = %s, want %s", tt.mime, tt.ext, category, tt.category)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/astaxie/beego/logs"
+	"golang.org/x/sys/windows"
+)
+
+var duplicatesMode = flag.Bool("duplicates", false, "print the duplicate files in the index and exit")
+var duplicatesRoot = flag.String("root", "", "only search under this directory")
+var duplicatesMinSize = flag.Int64("min-size", int64(DUPLICATE_MIN_SIZE), "the minimum file size in bytes")
+var duplicatesJSON = flag.Bool("json", false, "print the report as json")
+
+var ATTACH_PARENT_PROCESS = uintptr(^uint32(0))
+
+var procAttachConsole = windows.NewLazySystemDLL("kernel32.dll").NewProc("AttachConsole")
+
+func stdHandleValid(id uint32) bool {
+	handle, err := windows.GetStdHandle(id)
+	return err == nil && handle != 0 && handle != windows.InvalidHandle
+}
+
+// ConsoleAttach attaches to the console of the parent process, the binary
+// is linked as a gui program and starts without one. The standard handles
+// redirected to a file or pipe are kept.
+func ConsoleAttach() {
+	stdout, stderr := stdHandleValid(windows.STD_OUTPUT_HANDLE), stdHandleValid(windows.STD_ERROR_HANDLE)
+	if stdout && stderr {
+		return
+	}
+
+	ret, _, err := procAttachConsole.Call(ATTACH_PARENT_PROCESS)
+	if ret == 0 {
+		logs.Warning("attach parent console failed, %s", err.Error())
+		return
+	}
+
+	console, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		logs.Warning("open console output failed, %s", err.Error())
+		return
+	}
+	if !stdout {
+		os.Stdout = console
+	}
+	if !stderr {
+		os.Stderr = console
+	}
+}
+
+// DuplicatesMain prints the duplicate groups of the index to stdout. The
+// index is opened read-only when the gui instance owns it, in that case the
+// computed hashes are not cached.
+func DuplicatesMain() {
+	FileInit()
+	LogInit()
+	logs.GetBeeLogger().DelLogger(logs.AdapterConsole)
+	ConfigInit()
+	ConsoleAttach()
+
+	lock, err := IndexLockAcquire()
+	if err != nil {
+		logs.Warning("index lock failed, open read-only, %s", err.Error())
+	}
+
+	sql, err := NewSQLiteDB(lock == nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open index failed, %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	scopes := make([]string, 0)
+	if *duplicatesRoot != "" {
+		scopes = append(scopes, filepath.Clean(*duplicatesRoot))
+	}
+
+	progress := StartProgress("duplicates", ProgressFunc(func(current, total int64, message string) {
+		fmt.Fprintf(os.Stderr, "\r%d/%d", current, total)
+	}))
+	report, err := FindDuplicates(ctx, sql, *duplicatesMinSize, progress, scopes...)
+	progress.Done("")
+	fmt.Fprintln(os.Stderr)
+
+	sql.Close()
+	if lock != nil {
+		lock.Release()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "find duplicates failed, %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *duplicatesJSON {
+		body, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(body))
+		return
+	}
+
+	for _, group := range report.Groups {
+		fmt.Printf("%s x %d, %s wasted, sha256 %s\n", ByteView(group.Size), len(group.Files), ByteView(group.Wasted), group.Hash)
+		for _, path := range group.Files {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+	fmt.Printf("%d groups, %s wasted\n", len(report.Groups), ByteView(report.Wasted))
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var COALESCE_WINDOW = 300 * time.Millisecond
+var COALESCE_MAX_DELAY = 2 * time.Second
+var COALESCE_TICK = 100 * time.Millisecond
+var COALESCE_BATCH = 1000
+var COALESCE_MAX_PENDING = 200000
+var COALESCE_MAX_DIRTY = 1000
+var DIRTY_META_KEY = "dirty_dirs"
+
+type pendingEvent struct {
+	event   uint32
+	oldPath string // the old name of a rename
+	seq     uint64
+	first   time.Time
+	last    time.Time
+}
+
+type CoalesceStatus struct {
+	Received    uint64    `json:"received"`
+	Merged      uint64    `json:"merged"`
+	Dropped     uint64    `json:"dropped"`
+	Pending     int       `json:"pending"`
+	PendingMax  int       `json:"pending_max"`
+	PendingPeak int       `json:"pending_peak"`
+	Dirty       int       `json:"dirty"`
+	Spilled     uint64    `json:"spilled"`
+	Batches     uint64    `json:"batches"`
+	Applied     uint64    `json:"applied"`
+	SendWait    string    `json:"send_wait"`
+	LastFlush   time.Time `json:"last_flush,omitempty"`
+}
+
+// Coalescer sits between the watchers and the index. It merges the raw
+// events of a path until the path was quiet for COALESCE_WINDOW, or at most
+// COALESCE_MAX_DELAY, then stats the path once and sends the changes in
+// batches. The watchers only touch the pending map and never wait for the
+// index, when COALESCE_MAX_PENDING paths are pending the parent directories
+// of further events are marked dirty and rescanned once the index caught up.
+type Coalescer struct {
+	sync.Mutex
+	sync.WaitGroup
+
+	sql      *SQLiteDB
+	config   func() Config
+	rescan   func(roots ...string)
+	pending  map[string]*pendingEvent
+	dirty    map[string]bool
+	seq      uint64
+	status   CoalesceStatus
+	sendWait time.Duration
+	closed   bool
+	stop     chan struct{}
+}
+
+func NewCoalescer(sql *SQLiteDB, config func() Config, rescan func(roots ...string)) *Coalescer {
+	c := &Coalescer{sql: sql, config: config, rescan: rescan, pending: make(map[string]*pendingEvent),
+		dirty: make(map[string]bool), stop: make(chan struct{})}
+	c.Add(1)
+	go c.flushTask()
+	return c
+}
+
+// merge folds a new event into the pending one, false drops both, such as
+// a file added and removed again within the window.
+func merge(pending uint32, event uint32) (uint32, bool) {
+	switch pending {
+	case FILE_ADD:
+		if event == FILE_REMOVE {
+			return 0, false
+		}
+		return FILE_ADD, true
+	case FILE_MODIFIED:
+		if event == FILE_REMOVE {
+			return FILE_REMOVE, true
+		}
+		return FILE_MODIFIED, true
+	case FILE_REMOVE:
+		if event == FILE_REMOVE {
+			return FILE_REMOVE, true
+		}
+		return FILE_MODIFIED, true
+	case FILE_RENAME_NEW:
+		if event == FILE_REMOVE {
+			return FILE_REMOVE, true
+		}
+		return FILE_RENAME_NEW, true
+	}
+	return event, true
+}
+
+// full marks the parent of path dirty when no more paths can be pending,
+// called with the lock held.
+func (c *Coalescer) full(path string) bool {
+	if _, ok := c.pending[path]; ok || len(c.pending) < COALESCE_MAX_PENDING {
+		return false
+	}
+
+	c.markDirty(path)
+	return true
+}
+
+// markDirty remembers the parent of path for a rescan, past
+// COALESCE_MAX_DIRTY directories the drive is marked instead.
+func (c *Coalescer) markDirty(path string) {
+	dir := filepath.Dir(path)
+	if len(c.dirty) >= COALESCE_MAX_DIRTY && len(path) >= 3 {
+		dir = path[:3]
+	}
+	c.dirty[dir] = true
+	c.status.Spilled++
+}
+
+// Event queues a raw add, modify or remove event of path.
+func (c *Coalescer) Event(event uint32, path string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.status.Received++
+
+	if c.full(path) {
+		return
+	}
+
+	now := time.Now()
+	v, ok := c.pending[path]
+	if !ok {
+		c.seq++
+		c.pending[path] = &pendingEvent{event: event, seq: c.seq, first: now, last: now}
+		c.status.PendingPeak = max(c.status.PendingPeak, len(c.pending))
+		return
+	}
+
+	c.status.Merged++
+	merged, keep := merge(v.event, event)
+	if !keep {
+		delete(c.pending, path)
+		return
+	}
+	if v.event == FILE_RENAME_NEW && merged == FILE_REMOVE {
+		// renamed and removed, only the old name has to go
+		delete(c.pending, path)
+		c.seq++
+		c.pending[v.oldPath] = &pendingEvent{event: FILE_REMOVE, seq: c.seq, first: v.first, last: now}
+		return
+	}
+	v.event = merged
+	v.last = now
+}
+
+// Rename queues a rename, the old and new name are sent together.
+func (c *Coalescer) Rename(oldPath string, newPath string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.status.Received += 2
+
+	if c.full(newPath) {
+		c.markDirty(oldPath)
+		return
+	}
+
+	now := time.Now()
+	first := now
+	old, ok := c.pending[oldPath]
+	if ok {
+		delete(c.pending, oldPath)
+		c.status.Merged++
+		first = old.first
+	}
+	delete(c.pending, newPath)
+
+	c.seq++
+	if ok && old.event == FILE_ADD {
+		// added and renamed, the old name never reached the index
+		c.pending[newPath] = &pendingEvent{event: FILE_ADD, seq: c.seq, first: first, last: now}
+		return
+	}
+	if ok && old.event == FILE_RENAME_NEW {
+		oldPath = old.oldPath
+	}
+	c.pending[newPath] = &pendingEvent{event: FILE_RENAME_NEW, oldPath: oldPath, seq: c.seq, first: first, last: now}
+	c.status.PendingPeak = max(c.status.PendingPeak, len(c.pending))
+}
+
+// Drop counts an event discarded before coalescing, such as an excluded path.
+func (c *Coalescer) Drop() {
+	c.Lock()
+	c.status.Dropped++
+	c.Unlock()
+}
+
+func (c *Coalescer) Status() CoalesceStatus {
+	c.Lock()
+	defer c.Unlock()
+
+	status := c.status
+	status.Pending = len(c.pending)
+	status.PendingMax = COALESCE_MAX_PENDING
+	status.Dirty = len(c.dirty)
+	status.SendWait = c.sendWait.String()
+	return status
+}
+
+// coalesceNotify stats path to build the changes to apply, with the extended
+// metadata when meta is set. An add or modify of a path gone meanwhile turns
+// into a remove, or nothing for an add.
+func coalesceNotify(v *pendingEvent, path string, meta bool) []*FileNotify {
+	if v.event == FILE_REMOVE {
+		return []*FileNotify{{Event: FILE_REMOVE, File: FileInfo{Path: path}}}
+	}
+	newInfo := NewFileInfo
+	if meta {
+		newInfo = NewFileInfoMeta
+	}
+	fileInfo, err := newInfo(path)
+	if v.event == FILE_RENAME_NEW {
+		if err != nil {
+			return []*FileNotify{{Event: FILE_REMOVE, File: FileInfo{Path: v.oldPath}}}
+		}
+		return []*FileNotify{
+			{Event: FILE_RENAME_OLD, File: FileInfo{Path: v.oldPath}},
+			{Event: FILE_RENAME_NEW, File: *fileInfo},
+		}
+	}
+	if err != nil {
+		if v.event == FILE_ADD {
+			return nil
+		}
+		return []*FileNotify{{Event: FILE_REMOVE, File: FileInfo{Path: path}}}
+	}
+	return []*FileNotify{{Event: v.event, File: *fileInfo}}
+}
+
+func (c *Coalescer) flushTask() {
+	defer c.Done()
+
+	ticker := time.NewTicker(COALESCE_TICK)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flush(false)
+			c.flushDirty()
+		case <-c.stop:
+			c.flush(true)
+			c.persistDirty()
+			return
+		}
+	}
+}
+
+func (c *Coalescer) flush(all bool) {
+	now := time.Now()
+
+	type ready struct {
+		path  string
+		event *pendingEvent
+	}
+
+	c.Lock()
+	list := make([]ready, 0)
+	for path, v := range c.pending {
+		if all || now.Sub(v.last) >= COALESCE_WINDOW || now.Sub(v.first) >= COALESCE_MAX_DELAY {
+			list = append(list, ready{path: path, event: v})
+			delete(c.pending, path)
+		}
+	}
+	c.Unlock()
+
+	if len(list) == 0 {
+		return
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].event.seq < list[j].event.seq
+	})
+
+	meta := c.config().IndexMeta
+	batch := make([]*FileNotify, 0, min(len(list), COALESCE_BATCH))
+	for _, v := range list {
+		batch = append(batch, coalesceNotify(v.event, v.path, meta)...)
+		if len(batch) >= COALESCE_BATCH {
+			c.send(batch)
+			batch = make([]*FileNotify, 0, COALESCE_BATCH)
+		}
+	}
+	c.send(batch)
+}
+
+// send hands a batch to the index, waiting while its queue is full until
+// the index is closed. Only the flush task waits here, the watchers keep
+// queueing meanwhile.
+func (c *Coalescer) send(batch []*FileNotify) {
+	if len(batch) == 0 {
+		return
+	}
+
+	start := time.Now()
+	select {
+	case c.sql.Notify() <- batch:
+	case <-c.sql.Closed():
+		logs.Warning("index closed, drop %d file events", len(batch))
+		return
+	}
+
+	c.Lock()
+	c.sendWait = time.Since(start)
+	c.status.Batches++
+	c.status.Applied += uint64(len(batch))
+	c.status.LastFlush = time.Now()
+	c.Unlock()
+}
+
+// takeDirty returns the dirty directories without those below another
+// dirty directory, and clears them.
+func (c *Coalescer) takeDirty() []string {
+	c.Lock()
+	dirs := make([]string, 0, len(c.dirty))
+	for dir := range c.dirty {
+		dirs = append(dirs, dir)
+	}
+	c.dirty = make(map[string]bool)
+	c.Unlock()
+
+	return PathsOutermost(dirs)
+}
+
+// flushDirty rescans the dirty directories once the pending events and the
+// index queue drained to half.
+func (c *Coalescer) flushDirty() {
+	c.Lock()
+	ready := len(c.dirty) > 0 && len(c.pending) < COALESCE_MAX_PENDING/2
+	c.Unlock()
+
+	queue := c.sql.Notify()
+	if !ready || len(queue) > cap(queue)/2 || c.rescan == nil {
+		return
+	}
+
+	dirs := c.takeDirty()
+	logs.Info("rescan %d dirty directories", len(dirs))
+	c.rescan(dirs...)
+}
+
+// persistDirty keeps the dirty directories in the index meta, they are
+// rescanned at the next startup.
+func (c *Coalescer) persistDirty() {
+	dirs := c.takeDirty()
+	if len(dirs) == 0 {
+		return
+	}
+
+	exist := make([]string, 0)
+	_ = json.Unmarshal([]byte(c.sql.MetaGet(DIRTY_META_KEY)), &exist)
+
+	body, _ := json.Marshal(append(exist, dirs...))
+	c.sql.MetaSet(DIRTY_META_KEY, string(body))
+
+	logs.Info("persist %d dirty directories", len(dirs))
+}
+
+// Close sends the pending events, persists the dirty directories and stops
+// the coalescer.
+func (c *Coalescer) Close() {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return
+	}
+	c.closed = true
+	c.Unlock()
+
+	close(c.stop)
+	c.Wait()
+}
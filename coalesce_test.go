@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCoalesceMerge(t *testing.T) {
+	tests := []struct {
+		pending uint32
+		event   uint32
+		want    uint32
+		keep    bool
+	}{
+		{FILE_ADD, FILE_MODIFIED, FILE_ADD, true},
+		{FILE_ADD, FILE_ADD, FILE_ADD, true},
+		{FILE_ADD, FILE_REMOVE, 0, false},
+		{FILE_MODIFIED, FILE_MODIFIED, FILE_MODIFIED, true},
+		{FILE_MODIFIED, FILE_ADD, FILE_MODIFIED, true},
+		{FILE_MODIFIED, FILE_REMOVE, FILE_REMOVE, true},
+		{FILE_REMOVE, FILE_REMOVE, FILE_REMOVE, true},
+		{FILE_REMOVE, FILE_ADD, FILE_MODIFIED, true},
+		{FILE_REMOVE, FILE_MODIFIED, FILE_MODIFIED, true},
+		{FILE_RENAME_NEW, FILE_MODIFIED, FILE_RENAME_NEW, true},
+		{FILE_RENAME_NEW, FILE_REMOVE, FILE_REMOVE, true},
+	}
+
+	for _, tt := range tests {
+		got, keep := merge(tt.pending, tt.event)
+		if got != tt.want || keep != tt.keep {
+			t.Errorf("merge(%d, %d) = %d, %v, want %d, %v", tt.pending, tt.event, got, keep, tt.want, tt.keep)
+		}
+	}
+}
+
+// coalesceStep is one raw event, a rename when old is set.
+type coalesceStep struct {
+	event uint32
+	old   string
+	path  string
+}
+
+type coalesceWant struct {
+	event   uint32
+	oldPath string
+}
+
+func TestCoalescerEvents(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []coalesceStep
+		want  map[string]coalesceWant
+	}{
+		{
+			name:  "modified twice",
+			steps: []coalesceStep{{event: FILE_MODIFIED, path: `C:\a`}, {event: FILE_MODIFIED, path: `C:\a`}},
+			want:  map[string]coalesceWant{`C:\a`: {event: FILE_MODIFIED}},
+		},
+		{
+			name:  "added and removed",
+			steps: []coalesceStep{{event: FILE_ADD, path: `C:\a`}, {event: FILE_REMOVE, path: `C:\a`}},
+			want:  map[string]coalesceWant{},
+		},
+		{
+			name:  "removed and added",
+			steps: []coalesceStep{{event: FILE_REMOVE, path: `C:\a`}, {event: FILE_ADD, path: `C:\a`}},
+			want:  map[string]coalesceWant{`C:\a`: {event: FILE_MODIFIED}},
+		},
+		{
+			name:  "renamed",
+			steps: []coalesceStep{{old: `C:\a`, path: `C:\b`}},
+			want:  map[string]coalesceWant{`C:\b`: {event: FILE_RENAME_NEW, oldPath: `C:\a`}},
+		},
+		{
+			name:  "added and renamed",
+			steps: []coalesceStep{{event: FILE_ADD, path: `C:\a`}, {old: `C:\a`, path: `C:\b`}},
+			want:  map[string]coalesceWant{`C:\b`: {event: FILE_ADD}},
+		},
+		{
+			name:  "renamed twice",
+			steps: []coalesceStep{{old: `C:\a`, path: `C:\b`}, {old: `C:\b`, path: `C:\c`}},
+			want:  map[string]coalesceWant{`C:\c`: {event: FILE_RENAME_NEW, oldPath: `C:\a`}},
+		},
+		{
+			name:  "renamed and removed",
+			steps: []coalesceStep{{old: `C:\a`, path: `C:\b`}, {event: FILE_REMOVE, path: `C:\b`}},
+			want:  map[string]coalesceWant{`C:\a`: {event: FILE_REMOVE}},
+		},
+		{
+			name:  "modified and renamed",
+			steps: []coalesceStep{{event: FILE_MODIFIED, path: `C:\a`}, {old: `C:\a`, path: `C:\b`}},
+			want:  map[string]coalesceWant{`C:\b`: {event: FILE_RENAME_NEW, oldPath: `C:\a`}},
+		},
+		{
+			name:  "renamed over a pending file",
+			steps: []coalesceStep{{event: FILE_MODIFIED, path: `C:\b`}, {old: `C:\a`, path: `C:\b`}},
+			want:  map[string]coalesceWant{`C:\b`: {event: FILE_RENAME_NEW, oldPath: `C:\a`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Coalescer{pending: make(map[string]*pendingEvent), dirty: make(map[string]bool)}
+			for _, v := range tt.steps {
+				if v.old != "" {
+					c.Rename(v.old, v.path)
+				} else {
+					c.Event(v.event, v.path)
+				}
+			}
+
+			if len(c.pending) != len(tt.want) {
+				t.Fatalf("pending %d paths, want %d", len(c.pending), len(tt.want))
+			}
+			for path, want := range tt.want {
+				v, ok := c.pending[path]
+				if !ok {
+					t.Fatalf("%s not pending", path)
+				}
+				if v.event != want.event || v.oldPath != want.oldPath {
+					t.Errorf("%s = %d %q, want %d %q", path, v.event, v.oldPath, want.event, want.oldPath)
+				}
+			}
+		})
+	}
+}
+
+func TestCoalescerFull(t *testing.T) {
+	max := COALESCE_MAX_PENDING
+	COALESCE_MAX_PENDING = 1
+	defer func() { COALESCE_MAX_PENDING = max }()
+
+	c := &Coalescer{pending: make(map[string]*pendingEvent), dirty: make(map[string]bool)}
+	c.Event(FILE_MODIFIED, `C:\dir\a`)
+	c.Event(FILE_MODIFIED, `C:\dir\a`)
+	c.Event(FILE_MODIFIED, `C:\other\b`)
+
+	if len(c.pending) != 1 || c.pending[`C:\dir\a`] == nil {
+		t.Fatalf("pending %v, want only C:\\dir\\a", c.pending)
+	}
+	if !c.dirty[`C:\other`] || len(c.dirty) != 1 {
+		t.Errorf("dirty %v, want C:\\other", c.dirty)
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/astaxie/beego/logs"
 	"golang.org/x/sys/windows"
@@ -21,12 +26,24 @@ type Config struct {
 	McpPort   int    `json:"mcp_port"`   // mcp server listen port
 	McpEnable bool   `json:"map_enable"` // mcp server enable
 
+	McpSSE        bool `json:"mcp_sse_enable"`        // mcp sse transport enable (/sse, /message)
+	McpStreamable bool `json:"mcp_streamable_enable"` // mcp streamable http transport enable (/mcp)
+
+	ResourcePinned  []string `json:"resource_pinned"`   // pinned files listed as mcp resources
+	ResourceRecent  int      `json:"resource_recent"`   // number of recent files listed as mcp resources
+	ResourceMaxSize int64    `json:"resource_max_size"` // max file size readable as mcp resource
+
+	JournalDays int `json:"journal_retention_days"` // days of file changes kept in the journal
+	JournalRows int `json:"journal_max_rows"`       // max file changes kept in the journal
+
 	SearchDrives []DriveConfig `json:"search_drives"`        // drive name list
 	FilterRegexp []string      `json:"filter_regexp"`        // filter regex list
 	FilterFolder []string      `json:"filter_folder"`        // filter folder list
 	FilterHide   bool          `json:"filter_hide_folder"`   // filter hide
 	FilterSystem bool          `json:"filter_system_folder"` // filter system folder
 	FileNotify   bool          `json:"file_notify_enable"`   // filesystem change event notify
+	IndexMeta    bool          `json:"index_metadata"`       // index owner, times, inode and links, slower scans
+	ExtGroups    []ExtGroup    `json:"ext_groups"`           // named extension lists for queries
 
 	AutoHide    bool `json:"auto_hide_windows"`   // auto hide
 	AutoStartup bool `json:"auto_startup_system"` // auto startup
@@ -53,6 +70,13 @@ func (c *Config) CheckFolder(path string) bool {
 		}
 	}
 
+	for _, v := range c.FilterRegexp {
+		re := filterRegexpGet(v)
+		if re != nil && re.MatchString(path) {
+			return true
+		}
+	}
+
 	if c.FilterHide || c.FilterSystem {
 		attr, err := GetPathAttributes(path)
 		if err != nil {
@@ -77,18 +101,56 @@ func (c *Config) CheckFolder(path string) bool {
 	return false
 }
 
+var filterRegexpLock sync.Mutex
+var filterRegexpCache = make(map[string]*regexp.Regexp)
+
+// filterRegexpGet compiles the filter expression once, an invalid one is
+// logged and matches nothing.
+func filterRegexpGet(expr string) *regexp.Regexp {
+	filterRegexpLock.Lock()
+	defer filterRegexpLock.Unlock()
+
+	re, ok := filterRegexpCache[expr]
+	if !ok {
+		var err error
+		re, err = regexp.Compile(expr)
+		if err != nil {
+			logs.Warning("filter regexp %s invalid, %s", expr, err.Error())
+		}
+		filterRegexpCache[expr] = re
+	}
+	return re
+}
+
+func (c *Config) CheckAccess(path string) bool {
+	for _, v := range c.SearchDrives {
+		if v.Enable && strings.HasPrefix(strings.ToLower(path), strings.ToLower(v.Name)) {
+			return !c.CheckFolder(path)
+		}
+	}
+	return false
+}
+
 var configCache = Config{
-	McpListen:    "0.0.0.0",
-	McpPort:      8888,
-	McpEnable:    true,
-	SearchDrives: []DriveConfig{},
-	FilterFolder: []string{"C:\\Windows", "C:\\Program Files", "C:\\Program Files (x86)", "C:\\ProgramData"},
-	FilterRegexp: []string{},
-	FilterHide:   true,
-	FilterSystem: true,
-	AutoHide:     false,
-	AutoStartup:  false,
-	CacheLength:  1024 * 1024,
+	McpListen:       "0.0.0.0",
+	McpPort:         8888,
+	McpEnable:       true,
+	McpSSE:          true,
+	McpStreamable:   true,
+	ResourcePinned:  []string{},
+	ResourceRecent:  50,
+	ResourceMaxSize: 4 * 1024 * 1024,
+	JournalDays:     7,
+	JournalRows:     1000000,
+	SearchDrives:    []DriveConfig{},
+	FilterFolder:    []string{"C:\\Windows", "C:\\Program Files", "C:\\Program Files (x86)", "C:\\ProgramData"},
+	FilterRegexp:    []string{},
+	FilterHide:      true,
+	FilterSystem:    true,
+	ExtGroups:       DefaultExtGroups(),
+	AutoHide:        false,
+	AutoStartup:     false,
+	CacheLength:     1024 * 1024,
 }
 
 func init() {
@@ -101,7 +163,10 @@ func init() {
 	}
 }
 
+var CONFIG_WATCH_INTERVAL = 2 * time.Second
+
 var configFilePath string
+var configLock sync.Mutex
 
 func configSyncToFile() error {
 	value, err := json.MarshalIndent(configCache, "\t", " ")
@@ -113,6 +178,9 @@ func configSyncToFile() error {
 }
 
 func ConfigDriveExist(name string) bool {
+	configLock.Lock()
+	defer configLock.Unlock()
+
 	for _, v := range configCache.SearchDrives {
 		if v.Name == name && v.Enable {
 			return true
@@ -122,10 +190,16 @@ func ConfigDriveExist(name string) bool {
 }
 
 func ConfigGet() Config {
+	configLock.Lock()
+	defer configLock.Unlock()
+
 	return configCache
 }
 
 func ConfigSet(config Config) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
 	configCache = config
 	err := configSyncToFile()
 	if err != nil {
@@ -151,10 +225,80 @@ func AutoStartupSave(value bool) error {
 			return err
 		}
 	}
+	configLock.Lock()
+	defer configLock.Unlock()
+
 	configCache.AutoStartup = value
 	return configSyncToFile()
 }
 
+// ConfigReload reads config.json again, changed is false when it equals the
+// config in use, such as after our own save.
+func ConfigReload() (Config, bool, error) {
+	value, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return Config{}, false, fmt.Errorf("read config file failed, %s", err.Error())
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	config := configCache
+	config.SearchDrives = nil
+	config.FilterFolder = nil
+	config.FilterRegexp = nil
+	config.ResourcePinned = nil
+	config.ExtGroups = nil
+
+	err = json.Unmarshal(value, &config)
+	if err != nil {
+		return Config{}, false, fmt.Errorf("json unmarshal config failed, %s", err.Error())
+	}
+	if config.ExtGroups == nil {
+		config.ExtGroups = DefaultExtGroups()
+	}
+	if reflect.DeepEqual(config, configCache) {
+		return config, false, nil
+	}
+	configCache = config
+	return config, true, nil
+}
+
+// ConfigWatch calls apply with the reloaded config when config.json changed
+// on disk, until ctx is done.
+func ConfigWatch(ctx context.Context, apply func(Config)) {
+	ticker := time.NewTicker(CONFIG_WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	if info, err := os.Stat(configFilePath); err == nil {
+		modTime = info.ModTime()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(configFilePath)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+		}
+
+		config, changed, err := ConfigReload()
+		if err != nil {
+			logs.Warning("reload config failed, %s", err.Error())
+			continue
+		}
+		if changed {
+			logs.Info("config file changed, apply config")
+			apply(config)
+		}
+	}
+}
+
 func ConfigInit() {
 	var err error
 	var value []byte
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,7 +15,10 @@ import (
 )
 
 var DATABASE_FILE = "sqlite3.db"
-var NOTIFY_CACHE_LENGTH = 100000
+var NOTIFY_CACHE_LENGTH = 1024 // batches of file changes
+var QUERY_PROGRESS_ROWS = 1000
+var SQLITE_READERS = 4
+var SQLITE_BUSY_TIMEOUT = 5000 // ms
 
 type FileInfo struct {
 	Name  string // 文件名
@@ -24,6 +29,11 @@ type FileInfo struct {
 	Drive   string    // 磁盘符号
 	ModTime time.Time // 修改时间
 	Size    int64     // 文件大小
+
+	Meta FileMeta // 扩展元数据: 时间、权限、所有者、文件号与链接
+
+	Mime     string // MIME 类型, 后台识别前为空
+	Category string // 分类: document, image, audio, video, archive, code, executable, other
 }
 
 func (f *FileInfo) ToHeader() []string {
@@ -43,7 +53,52 @@ func (f *FileInfo) ToList() []string {
 	}
 }
 
+func (f *FileInfo) ToDetailHeader() []string {
+	return []string{
+		"creation time", "change time", "access time", "mode", "owner", "group",
+		"inode", "device", "hard links", "link target", "mime type", "category",
+	}
+}
+
+func (f *FileInfo) ToDetailList() []string {
+	timeGet := func(tm time.Time) string {
+		if tm.IsZero() {
+			return ""
+		}
+		return TimeStampGet(tm)
+	}
+	owner, group := f.Meta.Owner, f.Meta.Group
+	if owner == "" {
+		owner = f.Meta.UID
+	}
+	if group == "" {
+		group = f.Meta.GID
+	}
+	return []string{
+		timeGet(f.Meta.CreateTime), timeGet(f.Meta.ChangeTime), timeGet(f.Meta.AccessTime),
+		os.FileMode(f.Meta.Mode).String(), owner, group,
+		fmt.Sprintf("%d", f.Meta.Inode), fmt.Sprintf("%d", f.Meta.Device),
+		fmt.Sprintf("%d", f.Meta.Links), f.Meta.LinkTarget, f.Mime, f.Category,
+	}
+}
+
+// args returns the values of the file_info columns written by
+// TABLE_INSERT_SQL and TABLE_UPSERT_SQL.
+func (f *FileInfo) args() []interface{} {
+	args := []interface{}{f.Name, f.IsDir, f.Path, f.Ext, f.Drive, f.ModTime.UnixNano(), f.Size}
+	return append(args, f.Meta.args()...)
+}
+
 func NewFileInfo(filePath string) (*FileInfo, error) {
+	return newFileInfo(filePath, false)
+}
+
+// NewFileInfoMeta is NewFileInfo with the extended metadata read too.
+func NewFileInfoMeta(filePath string) (*FileInfo, error) {
+	return newFileInfo(filePath, true)
+}
+
+func newFileInfo(filePath string, meta bool) (*FileInfo, error) {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return nil, err
@@ -52,7 +107,7 @@ func NewFileInfo(filePath string) (*FileInfo, error) {
 	if fileInfo.IsDir() {
 		isDir = 1
 	}
-	return &FileInfo{
+	file := &FileInfo{
 		Name:    filepath.Base(filePath),
 		IsDir:   isDir,
 		Path:    filePath,
@@ -60,7 +115,11 @@ func NewFileInfo(filePath string) (*FileInfo, error) {
 		Drive:   filePath[:3],
 		ModTime: fileInfo.ModTime(),
 		Size:    fileInfo.Size(),
-	}, nil
+	}
+	if meta {
+		file.Meta = FileMetaGet(filePath, fileInfo)
+	}
+	return file, nil
 }
 
 type FileNotify struct {
@@ -68,91 +127,204 @@ type FileNotify struct {
 	File  FileInfo
 }
 
-var TABLE_CREATE_SQL = `
-CREATE TABLE IF NOT EXISTS file_info (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL,
-	is_dir INTEGER NOT NULL,
-	path TEXT NOT NULL UNIQUE,
-	ext TEXT NOT NULL,
-	drive TEXT NOT NULL,
-	mod_time TEXT NOT NULL,
-	size INTEGER NOT NULL
-);`
+var TABLE_META_SET_SQL = `
+INSERT INTO index_meta (key, value) VALUES (?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value`
 
-var TABLE_INDEX_SQL = `
-CREATE INDEX IF NOT EXISTS idx_file_info_name ON file_info (name, ext);
-`
+var TABLE_META_GET_SQL = `
+SELECT value FROM index_meta WHERE key = ?`
 
 var TABLE_INSERT_SQL = `
-INSERT INTO file_info (name, is_dir, path, ext, drive, mod_time, size)
-VALUES (?, ?, ?, ?, ?, ?, ?)`
-
-var TABLE_UPDATE_SQL = `
-UPDATE file_info
-SET name = ?, is_dir = ?, ext = ?, drive = ?, mod_time = ?, size = ?
-WHERE path = ?`
+INSERT INTO file_info (name, is_dir, path, ext, drive, mod_time, size,
+	btime, ctime, atime, mode, uid, gid, owner, grp, inode, device, nlink, link_target)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+var TABLE_UPSERT_SQL = `
+INSERT INTO file_info (name, is_dir, path, ext, drive, mod_time, size,
+	btime, ctime, atime, mode, uid, gid, owner, grp, inode, device, nlink, link_target)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(path) DO UPDATE SET
+mime = CASE WHEN mod_time = excluded.mod_time AND size = excluded.size THEN mime END,
+category = CASE WHEN mod_time = excluded.mod_time AND size = excluded.size THEN category END,
+name = excluded.name, is_dir = excluded.is_dir, ext = excluded.ext, drive = excluded.drive,
+mod_time = excluded.mod_time, size = excluded.size,
+btime = excluded.btime, ctime = excluded.ctime, atime = excluded.atime, mode = excluded.mode,
+uid = excluded.uid, gid = excluded.gid, owner = excluded.owner, grp = excluded.grp,
+inode = excluded.inode, device = excluded.device, nlink = excluded.nlink, link_target = excluded.link_target`
 
 var TABLE_DELETE_SQL = `
 DELETE FROM file_info WHERE path = ?`
 
-var TABLE_QUERY_LIKE_SQL = `
-SELECT name, is_dir, path, ext, drive, mod_time, size FROM file_info
-WHERE name LIKE ?
+var TABLE_DELETE_DRIVE_SQL = `
+DELETE FROM file_info WHERE drive = ?`
+
+var TABLE_COUNT_DRIVE_SQL = `
+SELECT drive, COUNT(*) FROM file_info GROUP BY drive`
+
+var TABLE_QUERY_PATH_PAGE_SQL = `
+SELECT path FROM file_info
+WHERE path > ?`
+
+var TABLE_QUERY_PATH_PAGE_LIMIT_SQL = `
+ORDER BY path
 LIMIT ?`
 
-var TABLE_QUERY_GLOB_SQL = `
-SELECT name, is_dir, path, ext, drive, mod_time, size FROM file_info
-WHERE name GLOB ?
+var TABLE_QUERY_SQL = `
+SELECT name, is_dir, path, ext, drive, mod_time, size,
+	btime, ctime, atime, mode, uid, gid, owner, grp, inode, device, nlink, link_target, mime, category
+FROM file_info
+WHERE 1 = 1`
+
+var TABLE_QUERY_RECENT_SQL = `
+SELECT name, is_dir, path, ext, drive, mod_time, size,
+	btime, ctime, atime, mode, uid, gid, owner, grp, inode, device, nlink, link_target, mime, category
+FROM file_info
+WHERE is_dir = 0`
+
+var TABLE_QUERY_LIMIT_SQL = `
+LIMIT ?`
+
+var TABLE_QUERY_RECENT_LIMIT_SQL = `
+ORDER BY mod_time DESC
 LIMIT ?`
 
+var TABLE_QUERY_PATH_NEXT_SQL = `
+SELECT path, is_dir FROM file_info
+WHERE path >= ? COLLATE NOCASE AND path < ? COLLATE NOCASE
+ORDER BY path COLLATE NOCASE
+LIMIT 1`
+
+var TABLE_QUERY_PATH_AFTER_SQL = `
+SELECT path, is_dir FROM file_info
+WHERE path > ? COLLATE NOCASE AND path < ? COLLATE NOCASE
+ORDER BY path COLLATE NOCASE
+LIMIT 1`
+
+var TABLE_QUERY_EXT_PREFIX_SQL = `
+SELECT DISTINCT ext FROM file_info
+WHERE ext LIKE ? ESCAPE '^' AND ext != ''`
+
+var TABLE_QUERY_EXT_PATH_SQL = `
+SELECT path FROM file_info
+WHERE ext = ?`
+
+// SQLiteDB writes through a single connection in WAL mode, serialized by
+// the lock, while queries run on a pool of read-only connections and see
+// the last committed state without waiting for the writer.
 type SQLiteDB struct {
 	sync.WaitGroup
-	sync.RWMutex
+	sync.Mutex
 
-	db     *sql.DB
-	notify chan interface{}
+	db       *sql.DB // writer
+	rdb      *sql.DB // readers
+	notify   chan interface{}
+	closed   chan struct{} // closed once the notify task stopped
+	readonly bool
+
+	listenerLock sync.Mutex
+	listenerID   int
+	listeners    map[int]func(*FileNotify)
+
+	journalDays      int
+	journalRows      int
+	journalPruneTime time.Time
+	renameOld        *FileInfo
+
+	rebuilds map[*usageRebuild]struct{} // guarded by the writer lock
 }
 
-func NewSQLiteDB() (*SQLiteDB, error) {
-	db, err := sql.Open("sqlite3", filepath.Join(ConfigDirGet(), DATABASE_FILE))
+func openReader(path string) (*sql.DB, error) {
+	rdb, err := sql.Open("sqlite3", fmt.Sprintf("file:///%s?mode=ro&_busy_timeout=%d",
+		filepath.ToSlash(path), SQLITE_BUSY_TIMEOUT))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite3 database read-only failed, %s", err.Error())
+	}
+	rdb.SetMaxOpenConns(SQLITE_READERS)
+	rdb.SetMaxIdleConns(SQLITE_READERS)
+	return rdb, nil
+}
+
+func NewSQLiteDB(readonly bool) (*SQLiteDB, error) {
+	path := filepath.Join(ConfigDirGet(), DATABASE_FILE)
+
+	if readonly {
+		rdb, err := openReader(path)
+		if err != nil {
+			return nil, err
+		}
+		version, err := schemaCheck(rdb)
+		if err != nil {
+			rdb.Close()
+			return nil, err
+		}
+		if version < SchemaLatest() {
+			rdb.Close()
+			return nil, fmt.Errorf("index schema v%d older than v%d, run the writer instance to migrate",
+				version, SchemaLatest())
+		}
+		logs.Info("sql open database read-only")
+		return &SQLiteDB{db: rdb, rdb: rdb, notify: make(chan interface{}, NOTIFY_CACHE_LENGTH),
+			closed: make(chan struct{}), readonly: true, listeners: make(map[int]func(*FileNotify))}, nil
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d",
+		path, SQLITE_BUSY_TIMEOUT))
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite3 database failed, %s", err.Error())
 	}
-	_, err = db.Exec(TABLE_CREATE_SQL)
+	db.SetMaxOpenConns(1)
+
+	err = migrate(db, path)
 	if err != nil {
-		return nil, fmt.Errorf("create table failed, %s", err.Error())
+		db.Close()
+		return nil, err
 	}
-	_, err = db.Exec(TABLE_INDEX_SQL)
+
+	rdb, err := openReader(path)
 	if err != nil {
-		return nil, fmt.Errorf("create index failed, %s", err.Error())
+		db.Close()
+		return nil, err
 	}
 
-	s := &SQLiteDB{db: db, notify: make(chan interface{}, NOTIFY_CACHE_LENGTH)}
+	s := &SQLiteDB{db: db, rdb: rdb, notify: make(chan interface{}, NOTIFY_CACHE_LENGTH),
+		closed: make(chan struct{}), listeners: make(map[int]func(*FileNotify)),
+		rebuilds: make(map[*usageRebuild]struct{})}
 	s.Add(1)
 	go recvNotifyTask(s)
 	return s, nil
 }
 
+func (s *SQLiteDB) ReadOnly() bool {
+	return s.readonly
+}
+
+// Reset clears the index, recorded as a rescan of the whole index.
 func (s *SQLiteDB) Reset() error {
+	if s.readonly {
+		return fmt.Errorf("index is read-only")
+	}
+
+	err := s.reset()
+	if err == nil {
+		s.Rescanned("")
+	}
+	return err
+}
+
+func (s *SQLiteDB) reset() error {
 	s.Lock()
 	defer s.Unlock()
 
-	_, err := s.db.Exec("DROP INDEX idx_file_info_name;")
-	if err != nil {
-		return fmt.Errorf("drop index failed, %s", err.Error())
-	}
-	_, err = s.db.Exec("DROP TABLE IF EXISTS file_info;")
+	_, err := s.db.Exec("DELETE FROM file_info;")
 	if err != nil {
-		return fmt.Errorf("drop table failed, %s", err.Error())
+		return fmt.Errorf("clear table failed, %s", err.Error())
 	}
-	_, err = s.db.Exec(TABLE_CREATE_SQL)
+	_, err = s.db.Exec("DELETE FROM dir_usage;")
 	if err != nil {
-		return fmt.Errorf("create table failed, %s", err.Error())
+		return fmt.Errorf("clear dir usage failed, %s", err.Error())
 	}
-	_, err = s.db.Exec(TABLE_INDEX_SQL)
-	if err != nil {
-		return fmt.Errorf("create index failed, %s", err.Error())
+	for rebuild := range s.rebuilds {
+		rebuild.dirty = true
 	}
 	logs.Info("sql reset database success")
 	return nil
@@ -164,94 +336,170 @@ func recvNotifyTask(s *SQLiteDB) {
 	logs.Info("sql recvice notify task startup")
 
 	for {
-		msg, ok := <-s.notify
-		if !ok {
-			break
-		}
+		msg := <-s.notify
 		if _, ok := msg.(struct{}); ok {
 			break
 		}
 
-		s.Lock()
-
-		if fileNotify, ok := msg.(*FileNotify); ok {
-			logs.Info("recive file event: %d path: %s", fileNotify.Event, fileNotify.File.Path)
-
-			switch fileNotify.Event {
-			case FILE_ADD:
-				{
-					_, err := s.db.Exec(TABLE_INSERT_SQL,
-						fileNotify.File.Name, fileNotify.File.IsDir,
-						fileNotify.File.Path, fileNotify.File.Ext,
-						fileNotify.File.Drive,
-						fileNotify.File.ModTime.Format(time.RFC3339),
-						fileNotify.File.Size)
-					if err != nil {
-						logs.Warning("insert sql failed, %s", err.Error())
-					}
-				}
-			case FILE_MODIFIED:
-				{
-					_, err := s.db.Exec(TABLE_UPDATE_SQL,
-						fileNotify.File.Name, fileNotify.File.IsDir,
-						fileNotify.File.Ext, fileNotify.File.Drive,
-						fileNotify.File.ModTime.Format(time.RFC3339),
-						fileNotify.File.Size, fileNotify.File.Path)
-					if err != nil {
-						logs.Warning("insert sql failed, %s", err.Error())
-					}
-				}
-			case FILE_REMOVE:
-				{
-					_, err := s.db.Exec(TABLE_DELETE_SQL, fileNotify.File.Path)
-					if err != nil {
-						logs.Warning("delete sql failed, %s", err.Error())
-					}
-				}
-
-			case FILE_RENAME_OLD:
-				{
-					_, err := s.db.Exec(TABLE_DELETE_SQL, fileNotify.File.Path)
-					if err != nil {
-						logs.Warning("delete sql failed, %s", err.Error())
-					}
-				}
-			case FILE_RENAME_NEW:
-				{
-					_, err := s.db.Exec(TABLE_INSERT_SQL,
-						fileNotify.File.Name, fileNotify.File.IsDir,
-						fileNotify.File.Path, fileNotify.File.Ext,
-						fileNotify.File.Drive,
-						fileNotify.File.ModTime.Format(time.RFC3339),
-						fileNotify.File.Size)
-					if err != nil {
-						logs.Warning("insert sql failed, %s", err.Error())
-					}
-				}
-			}
+		var batch []*FileNotify
+		switch v := msg.(type) {
+		case *FileNotify:
+			batch = []*FileNotify{v}
+		case []*FileNotify:
+			batch = v
+		}
+		if len(batch) == 0 {
+			continue
 		}
 
-		s.Unlock()
+		applied, err := s.applyBatch(batch)
+		if err != nil {
+			logs.Warning("apply %d file events failed, %s", len(batch), err.Error())
+			continue
+		}
+
+		for _, v := range applied {
+			s.notifyListeners(v)
+		}
 	}
 
 	logs.Info("sql recvice notify task shutdown")
 }
 
+// applyBatch applies a batch of file changes in one transaction and returns
+// the changes applied, none when the transaction failed to commit.
+func (s *SQLiteDB) applyBatch(batch []*FileNotify) ([]*FileNotify, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var db sqlExecer = s.db
+	tx, err := s.db.Begin()
+	if err != nil {
+		logs.Warning("begin transaction failed, %s", err.Error())
+	} else {
+		db = tx
+	}
+
+	applied := make([]*FileNotify, 0, len(batch))
+	for _, v := range batch {
+		if s.apply(db, v) {
+			applied = append(applied, v)
+		}
+	}
+
+	if tx != nil {
+		err = tx.Commit()
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("commit transaction failed, %s", err.Error())
+		}
+	}
+
+	logs.Info("apply %d file events", len(applied))
+	return applied, nil
+}
+
+// apply writes one file change, false when it failed.
+func (s *SQLiteDB) apply(db sqlExecer, fileNotify *FileNotify) bool {
+	logs.Debug("recive file event: %d path: %s", fileNotify.Event, fileNotify.File.Path)
+
+	file := fileNotify.File
+	isDir, size, exist := fileGet(db, file.Path)
+
+	switch fileNotify.Event {
+	case FILE_ADD, FILE_RENAME_NEW, FILE_MODIFIED:
+		{
+			_, err := db.Exec(TABLE_UPSERT_SQL, file.args()...)
+			if err != nil {
+				logs.Warning("upsert sql failed, %s", err.Error())
+				return false
+			}
+			if exist && (isDir == 0 || file.IsDir == 0) {
+				usageRemove(db, file.Path, isDir, size)
+			}
+			if file.IsDir == 0 {
+				usageAdd(db, UsageAncestors(file.Path), file.Size, 1)
+			}
+		}
+	case FILE_REMOVE, FILE_RENAME_OLD:
+		{
+			_, err := db.Exec(TABLE_DELETE_SQL, file.Path)
+			if err != nil {
+				logs.Warning("delete sql failed, %s", err.Error())
+				return false
+			}
+			if exist {
+				usageRemove(db, file.Path, isDir, size)
+			}
+			file.IsDir, file.Size = isDir, size
+		}
+	}
+
+	s.usageTouch(file.Path)
+	s.journal(db, fileNotify.Event, file)
+	return true
+}
+
+// AddListener registers a callback invoked after each file change was applied
+// to the index, the returned function removes it again.
+func (s *SQLiteDB) AddListener(fn func(*FileNotify)) func() {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+
+	s.listenerID++
+	id := s.listenerID
+	s.listeners[id] = fn
+
+	return func() {
+		s.listenerLock.Lock()
+		defer s.listenerLock.Unlock()
+		delete(s.listeners, id)
+	}
+}
+
+func (s *SQLiteDB) notifyListeners(notify *FileNotify) {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+
+	for _, fn := range s.listeners {
+		fn(notify)
+	}
+}
+
+// Close applies the queued file changes, waiting at most SHUTDOWN_TIMEOUT,
+// and closes the database.
 func (s *SQLiteDB) Close() {
-	s.notify <- struct{}{}
-	s.Wait()
+	if !s.readonly {
+		select {
+		case s.notify <- struct{}{}:
+		case <-time.After(SHUTDOWN_TIMEOUT):
+		}
+	}
+	if !WaitTimeout(&s.WaitGroup, SHUTDOWN_TIMEOUT) {
+		logs.Warning("sql notify task not stopped within %s", SHUTDOWN_TIMEOUT)
+	}
+	// the queue is never closed, a sender waiting on it is released here
+	close(s.closed)
 
 	s.Lock()
 	defer s.Unlock()
 
+	if !s.readonly {
+		err := s.rdb.Close()
+		if err != nil {
+			logs.Warning("sql readers close failed, %s", err.Error())
+		}
+		_, err = s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
+		if err != nil {
+			logs.Warning("sql wal checkpoint failed, %s", err.Error())
+		}
+	}
+
 	err := s.db.Close()
 	if err != nil {
 		logs.Warning("sql close faileld, %s", err.Error())
 	}
 
-	close(s.notify)
-	s.notify = nil
-
 	logs.Info("sql closed")
 }
 
@@ -259,24 +507,152 @@ func (s *SQLiteDB) Notify() chan interface{} {
 	return s.notify
 }
 
+// Closed is closed once the notify task stopped, the events sent after are
+// never applied.
+func (s *SQLiteDB) Closed() <-chan struct{} {
+	return s.closed
+}
+
 func (s *SQLiteDB) Write(file FileInfo) {
+	if s.readonly {
+		return
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
-	_, err := s.db.Exec(TABLE_INSERT_SQL,
-		file.Name, file.IsDir,
-		file.Path, file.Ext,
-		file.Drive,
-		file.ModTime.Format(time.RFC3339),
-		file.Size)
+	_, err := s.db.Exec(TABLE_INSERT_SQL, file.args()...)
 	if err != nil {
 		logs.Warning("insert sql %v failed, %s", file, err.Error())
 	}
+	s.usageTouch(file.Path)
+}
+
+func (s *SQLiteDB) Upsert(file FileInfo) {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.db.Exec(TABLE_UPSERT_SQL, file.args()...)
+	if err != nil {
+		logs.Warning("upsert sql %v failed, %s", file, err.Error())
+	}
+	s.usageTouch(file.Path)
+}
+
+func (s *SQLiteDB) Delete(path string) {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.db.Exec(TABLE_DELETE_SQL, path)
+	if err != nil {
+		logs.Warning("delete sql %s failed, %s", path, err.Error())
+	}
+	s.usageTouch(path)
+}
+
+// DriveDrop removes the entries and aggregates of a drive no longer indexed,
+// recorded as a rescan of the drive.
+func (s *SQLiteDB) DriveDrop(drive string) error {
+	if s.readonly {
+		return fmt.Errorf("index is read-only")
+	}
+
+	err := s.driveDrop(drive)
+	if err == nil {
+		s.Rescanned(drive)
+	}
+	return err
+}
+
+func (s *SQLiteDB) driveDrop(drive string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	result, err := s.db.Exec(TABLE_DELETE_DRIVE_SQL, drive)
+	if err != nil {
+		return fmt.Errorf("delete drive %s failed, %s", drive, err.Error())
+	}
+	root := strings.TrimSuffix(drive, string(filepath.Separator))
+	_, err = s.db.Exec(TABLE_USAGE_DELETE_SQL, drive, LikeEscape(root+string(filepath.Separator))+"%")
+	if err != nil {
+		return fmt.Errorf("delete dir usage %s failed, %s", drive, err.Error())
+	}
+	_, err = s.db.Exec(TABLE_META_SET_SQL, "last_scan:"+drive, "")
+	if err != nil {
+		logs.Warning("set meta last_scan:%s failed, %s", drive, err.Error())
+	}
+
+	s.usageTouch(drive)
+
+	cnt, _ := result.RowsAffected()
+	logs.Info("drive %s dropped from index, %d entries", drive, cnt)
+	return nil
+}
+
+func (s *SQLiteDB) MetaSet(key string, value string) {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.db.Exec(TABLE_META_SET_SQL, key, value)
+	if err != nil {
+		logs.Warning("set meta %s failed, %s", key, err.Error())
+	}
+}
+
+func (s *SQLiteDB) MetaGet(key string) string {
+	var value string
+	err := s.rdb.QueryRow(TABLE_META_GET_SQL, key).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		logs.Warning("get meta %s failed, %s", key, err.Error())
+	}
+	return value
+}
+
+func (s *SQLiteDB) CountByDrive() (map[string]int, error) {
+	rows, err := s.rdb.Query(TABLE_COUNT_DRIVE_SQL)
+	if err != nil {
+		logs.Warning("query drive count failed, %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	output := make(map[string]int)
+	for rows.Next() {
+		var drive string
+		var count int
+		if err := rows.Scan(&drive, &count); err != nil {
+			logs.Warning("find error during scan row: %v", err)
+			continue
+		}
+		output[drive] = count
+	}
+	return output, rows.Err()
+}
+
+// PathPage returns the next page of indexed paths under root after the given
+// path, used to walk the index without holding the lock for long.
+func (s *SQLiteDB) PathPage(root string, after string, limit int) ([]string, error) {
+	where, args := scopeWhere([]string{root})
+	args = append([]interface{}{after}, args...)
+	args = append(args, limit)
+	return s.queryStrings(TABLE_QUERY_PATH_PAGE_SQL+where+TABLE_QUERY_PATH_PAGE_LIMIT_SQL, args...)
 }
 
 func (s *SQLiteDB) Count() (int, error) {
 	var rowCount int
-	err := s.db.QueryRow("SELECT COUNT(*) AS row_count FROM file_info").Scan(&rowCount)
+	err := s.rdb.QueryRow("SELECT COUNT(*) AS row_count FROM file_info").Scan(&rowCount)
 	if err != nil {
 		logs.Error("query row count failed, %s", err.Error())
 		return -1, err
@@ -284,19 +660,256 @@ func (s *SQLiteDB) Count() (int, error) {
 	return rowCount, nil
 }
 
-func (s *SQLiteDB) Query(keyword string, limit int) ([]FileInfo, error) {
-	s.RLock()
-	defer s.RUnlock()
+// QueryFilter narrows a search, zero values do not filter. The mod_time,
+// size, ext and path indexes serve the ranges and the order.
+type QueryFilter struct {
+	Keyword        string // LIKE substring or GLOB of the name
+	Ext            string
+	Exts           []string // any of these extensions
+	Groups         []string // extension groups, resolved into Exts by Server.Find
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Owner          string // owner name or id
+	Category       string // document, image, audio, video, archive, code, executable, other
+	Mime           string // exact type, or a prefix ending with / such as image/
+	Order          string // name, recent, largest
+	Limit          int
+	Scopes         []string
+}
 
-	var rows *sql.Rows
-	var err error
+// parseTerms moves the type:<category or mime>, category:<category> and
+// group:<extension group> terms of the keyword into the filter, the other
+// words stay the keyword.
+func (f QueryFilter) parseTerms() QueryFilter {
+	words := make([]string, 0)
+	for _, word := range strings.Fields(f.Keyword) {
+		key, value, found := strings.Cut(word, ":")
+		switch {
+		case found && value != "" && strings.EqualFold(key, "group"):
+			f.Groups = append(f.Groups, value)
+		case found && value != "" && strings.EqualFold(key, "category"):
+			f.Category = strings.ToLower(value)
+		case found && value != "" && strings.EqualFold(key, "type"):
+			value = strings.TrimSuffix(strings.ToLower(value), "*")
+			if strings.Contains(value, "/") {
+				f.Mime = value
+			} else {
+				f.Category = value
+			}
+		default:
+			words = append(words, word)
+		}
+	}
+	if len(words) == len(strings.Fields(f.Keyword)) {
+		return f
+	}
+	f.Keyword = strings.Join(words, " ")
+	return f
+}
 
-	if IsGlobChar(keyword) {
-		rows, err = s.db.Query(TABLE_QUERY_GLOB_SQL, keyword, limit)
-	} else {
-		rows, err = s.db.Query(TABLE_QUERY_LIKE_SQL, "%"+keyword+"%", limit)
+func (f QueryFilter) where() (string, []interface{}) {
+	where := ""
+	args := make([]interface{}, 0)
+
+	f = f.parseTerms()
+
+	if IsGlobChar(f.Keyword) {
+		where += "\nAND name GLOB ?"
+		args = append(args, f.Keyword)
+	} else if f.Keyword != "" {
+		where += "\nAND name LIKE ?"
+		args = append(args, "%"+f.Keyword+"%")
+	}
+	if f.Ext != "" {
+		where += "\nAND ext = ? COLLATE NOCASE"
+		args = append(args, extNormalize(f.Ext))
+	}
+	if len(f.Exts) > 0 {
+		where += "\nAND ext COLLATE NOCASE IN (?" + strings.Repeat(", ?", len(f.Exts)-1) + ")"
+		for _, ext := range f.Exts {
+			args = append(args, extNormalize(ext))
+		}
+	}
+	if f.MinSize > 0 {
+		where += "\nAND size >= ?"
+		args = append(args, f.MinSize)
+	}
+	if f.MaxSize > 0 {
+		where += "\nAND size <= ?"
+		args = append(args, f.MaxSize)
+	}
+	if !f.ModifiedAfter.IsZero() {
+		where += "\nAND mod_time >= ?"
+		args = append(args, f.ModifiedAfter.UnixNano())
+	}
+	if !f.ModifiedBefore.IsZero() {
+		where += "\nAND mod_time < ?"
+		args = append(args, f.ModifiedBefore.UnixNano())
+	}
+
+	if f.Category != "" {
+		where += "\nAND category = ?"
+		args = append(args, f.Category)
 	}
+	if strings.HasSuffix(f.Mime, "/") {
+		// a range over the mime index, '0' follows '/'
+		where += "\nAND mime >= ? AND mime < ?"
+		args = append(args, f.Mime, strings.TrimSuffix(f.Mime, "/")+"0")
+	} else if f.Mime != "" {
+		where += "\nAND mime = ?"
+		args = append(args, f.Mime)
+	}
+	if f.Owner != "" {
+		where += "\nAND (owner = ? COLLATE NOCASE OR uid = ?)"
+		args = append(args, f.Owner, f.Owner)
+	}
+
+	scope, scopeArgs := scopeWhere(f.Scopes)
+	where += scope
+	args = append(args, scopeArgs...)
+
+	switch f.Order {
+	case "name":
+		where += "\nORDER BY name"
+	case "recent":
+		where += "\nORDER BY mod_time DESC"
+	case "largest":
+		where += "\nORDER BY size DESC"
+	}
+	return where, args
+}
+
+// Query searches file names by keyword, the scopes limit the result to
+// the given directories, no scope means the whole index.
+func (s *SQLiteDB) Query(keyword string, limit int, scopes ...string) ([]FileInfo, error) {
+	return s.QueryContext(context.Background(), nil, keyword, limit, scopes...)
+}
+
+// QueryContext is Query stopping when ctx is cancelled and reporting the
+// number of rows read to progress, which may be nil.
+func (s *SQLiteDB) QueryContext(ctx context.Context, progress Progress, keyword string, limit int, scopes ...string) ([]FileInfo, error) {
+	return s.Find(ctx, progress, QueryFilter{Keyword: keyword, Limit: limit, Scopes: scopes})
+}
+
+// Find returns the entries matching the filter, stopping when ctx is
+// cancelled and reporting the number of rows read to progress. Extension
+// groups need the config and are resolved by Server.Find, a group left in
+// the filter is an error rather than matching every extension.
+func (s *SQLiteDB) Find(ctx context.Context, progress Progress, filter QueryFilter) ([]FileInfo, error) {
+	filter = filter.parseTerms()
+	if len(filter.Groups) > 0 {
+		return nil, fmt.Errorf("extension group %s not resolved", strings.Join(filter.Groups, ", "))
+	}
+
+	where, args := filter.where()
+	args = append(args, filter.Limit)
+
+	return s.queryRowsContext(ctx, progress, int64(filter.Limit), TABLE_QUERY_SQL+where+TABLE_QUERY_LIMIT_SQL, args...)
+}
+
+func (s *SQLiteDB) Recent(limit int, scopes ...string) ([]FileInfo, error) {
+	where, args := scopeWhere(scopes)
+	args = append(args, limit)
+
+	return s.queryRows(TABLE_QUERY_RECENT_SQL+where+TABLE_QUERY_RECENT_LIMIT_SQL, args...)
+}
+
+func scopeWhere(scopes []string) (string, []interface{}) {
+	if len(scopes) == 0 {
+		return "", nil
+	}
+
+	clause := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	for _, scope := range scopes {
+		// a range over the nocase path index, ']' follows the separator
+		root := strings.TrimSuffix(scope, string(filepath.Separator))
+		clause = append(clause, "path = ? COLLATE NOCASE OR (path >= ? COLLATE NOCASE AND path < ? COLLATE NOCASE)")
+		args = append(args, root, root+string(filepath.Separator), root+"]")
+	}
+
+	return "\nAND (" + strings.Join(clause, " OR ") + ")", args
+}
+
+func LikeEscape(value string) string {
+	replacer := strings.NewReplacer("^", "^^", "%", "^%", "_", "^_")
+	return replacer.Replace(value)
+}
+
+// PathNext returns the first indexed path from cursor, or after it when
+// after is set, which starts with prefix ignoring case. It seeks the nocase
+// path index once, empty when there is none.
+func (s *SQLiteDB) PathNext(prefix string, cursor string, after bool) (string, bool, error) {
+	query := TABLE_QUERY_PATH_NEXT_SQL
+	if after {
+		query = TABLE_QUERY_PATH_AFTER_SQL
+	}
+
+	var path string
+	var isDir int
+	err := s.rdb.QueryRow(query, cursor, prefix+"\U0010FFFF").Scan(&path, &isDir)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return path, isDir > 0, err
+}
+
+// ExtPrefix returns the extensions starting with prefix of the files
+// within the scopes, no scope means the whole index.
+func (s *SQLiteDB) ExtPrefix(prefix string, limit int, scopes ...string) ([]string, error) {
+	where, args := scopeWhere(scopes)
+	args = append([]interface{}{LikeEscape(prefix) + "%"}, args...)
+	args = append(args, limit)
+
+	return s.queryStrings(TABLE_QUERY_EXT_PREFIX_SQL+where+TABLE_QUERY_LIMIT_SQL, args...)
+}
 
+// ExtPaths returns up to limit paths of the files with the extension
+// within the scopes.
+func (s *SQLiteDB) ExtPaths(ext string, limit int, scopes ...string) ([]string, error) {
+	where, args := scopeWhere(scopes)
+	args = append([]interface{}{ext}, args...)
+	args = append(args, limit)
+
+	return s.queryStrings(TABLE_QUERY_EXT_PATH_SQL+where+TABLE_QUERY_LIMIT_SQL, args...)
+}
+
+func (s *SQLiteDB) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.rdb.Query(query, args...)
+	if err != nil {
+		logs.Warning("query sql failed, %s", err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	output := make([]string, 0)
+
+	for rows.Next() {
+		var value string
+		err := rows.Scan(&value)
+		if err != nil {
+			logs.Warning("find error during scan row: %v", err)
+		} else {
+			output = append(output, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logs.Warning("find error during iteration: %v", err)
+	}
+
+	return output, nil
+}
+
+func (s *SQLiteDB) queryRows(query string, args ...interface{}) ([]FileInfo, error) {
+	return s.queryRowsContext(context.Background(), nil, 0, query, args...)
+}
+
+func (s *SQLiteDB) queryRowsContext(ctx context.Context, progress Progress, total int64, query string, args ...interface{}) ([]FileInfo, error) {
+	rows, err := s.rdb.QueryContext(ctx, query, args...)
 	if err != nil {
 		logs.Warning("query sql failed, %s", err.Error())
 		return nil, err
@@ -306,24 +919,39 @@ func (s *SQLiteDB) Query(keyword string, limit int) ([]FileInfo, error) {
 
 	output := make([]FileInfo, 0)
 
+	// about ten updates for a small limit, and one when done
+	interval := QUERY_PROGRESS_ROWS
+	if total > 0 {
+		interval = min(interval, max(1, int(total/10)))
+	}
+
 	for rows.Next() {
-		var name, path, ext, drive, modTimeStr string
+		var name, path, ext, drive string
 		var isDir int
-		var size int64
+		var modTime, size int64
+		var meta fileMetaRow
+		var mimeType, category sql.NullString
 
-		err := rows.Scan(&name, &isDir, &path, &ext, &drive, &modTimeStr, &size)
+		dest := append([]interface{}{&name, &isDir, &path, &ext, &drive, &modTime, &size}, meta.dest()...)
+		err := rows.Scan(append(dest, &mimeType, &category)...)
 		if err != nil {
 			logs.Warning("find error during scan row: %v", err)
 		} else {
-			modTime, err := time.Parse(time.RFC3339, modTimeStr)
-			if err != nil {
-				logs.Warning("parse mod time %s failed, %s", modTimeStr, err.Error())
-			}
-			output = append(output, FileInfo{Name: name, IsDir: isDir, Path: path, Ext: ext, Drive: drive, ModTime: modTime, Size: size})
+			output = append(output, FileInfo{Name: name, IsDir: isDir, Path: path, Ext: ext, Drive: drive, ModTime: time.Unix(0, modTime), Size: size, Meta: meta.meta(),
+				Mime: mimeType.String, Category: category.String})
+		}
+		if progress != nil && len(output)%interval == 0 {
+			progress.Update(int64(len(output)), total, path)
 		}
 	}
 	if err := rows.Err(); err != nil {
 		logs.Warning("find error during iteration: %v", err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if progress != nil && len(output)%interval != 0 {
+		progress.Update(int64(len(output)), total, "")
 	}
 
 	return output, nil
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var TABLE_CLASSIFY_PAGE_SQL = `
+SELECT id, path, ext, mod_time, size FROM file_info
+WHERE mime IS NULL AND is_dir = 0 AND id > ?
+ORDER BY id
+LIMIT ?`
+
+var TABLE_CLASSIFY_PENDING_SQL = `
+SELECT COUNT(*) FROM (
+	SELECT id FROM file_info WHERE mime IS NULL AND is_dir = 0 LIMIT 1
+)`
+
+var TABLE_CLASSIFY_COUNT_SQL = `
+SELECT COUNT(*) FROM file_info WHERE mime IS NULL AND is_dir = 0`
+
+var TABLE_CLASSIFY_SET_SQL = `
+UPDATE file_info SET mime = ?, category = ?
+WHERE id = ? AND mod_time = ? AND size = ?`
+
+// fileClass is a file waiting for its type, the update is skipped when the
+// file changed since it was read.
+type fileClass struct {
+	ID       int64
+	Path     string
+	Ext      string
+	ModTime  int64
+	Size     int64
+	Mime     string
+	Category string
+}
+
+// ClassifyPending reports whether files are waiting to be classified.
+func (s *SQLiteDB) ClassifyPending() bool {
+	var cnt int
+	err := s.rdb.QueryRow(TABLE_CLASSIFY_PENDING_SQL).Scan(&cnt)
+	if err != nil {
+		logs.Warning("query unclassified files failed, %s", err.Error())
+		return false
+	}
+	return cnt > 0
+}
+
+func (s *SQLiteDB) ClassifyCount() int64 {
+	var cnt int64
+	err := s.rdb.QueryRow(TABLE_CLASSIFY_COUNT_SQL).Scan(&cnt)
+	if err != nil {
+		logs.Warning("count unclassified files failed, %s", err.Error())
+	}
+	return cnt
+}
+
+// ClassifyPage returns up to limit unclassified files with an id above after.
+func (s *SQLiteDB) ClassifyPage(after int64, limit int) ([]fileClass, error) {
+	rows, err := s.rdb.Query(TABLE_CLASSIFY_PAGE_SQL, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query unclassified files failed, %s", err.Error())
+	}
+	defer rows.Close()
+
+	output := make([]fileClass, 0)
+	for rows.Next() {
+		var file fileClass
+		err = rows.Scan(&file.ID, &file.Path, &file.Ext, &file.ModTime, &file.Size)
+		if err != nil {
+			return nil, fmt.Errorf("scan unclassified file failed, %s", err.Error())
+		}
+		output = append(output, file)
+	}
+	return output, rows.Err()
+}
+
+// ClassifySet stores the types of files in one transaction, files without
+// a type are left for the next run.
+func (s *SQLiteDB) ClassifySet(files []fileClass) error {
+	if s.readonly {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction failed, %s", err.Error())
+	}
+
+	for _, file := range files {
+		if file.Mime == "" {
+			continue
+		}
+		_, err = tx.Exec(TABLE_CLASSIFY_SET_SQL, file.Mime, file.Category, file.ID, file.ModTime, file.Size)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("set type of %s failed, %s", file.Path, err.Error())
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit file types failed, %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var TABLE_HASH_GET_SQL = `
+SELECT partial, full FROM file_hash WHERE path = ? AND mod_time = ? AND size = ?`
+
+var TABLE_HASH_SET_SQL = `
+INSERT INTO file_hash (path, mod_time, size, partial, full) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(path) DO UPDATE SET
+mod_time = excluded.mod_time, size = excluded.size, partial = excluded.partial, full = excluded.full`
+
+var TABLE_HASH_PRUNE_SQL = `
+DELETE FROM file_hash WHERE path NOT IN (SELECT path FROM file_info)`
+
+var TABLE_QUERY_SIZE_DUP_SQL = `
+SELECT name, is_dir, path, ext, drive, mod_time, size,
+	btime, ctime, atime, mode, uid, gid, owner, grp, inode, device, nlink, link_target, mime, category
+FROM file_info
+WHERE is_dir = 0 AND size >= ?`
+
+var TABLE_QUERY_SIZE_DUP_IN_SQL = `
+AND size IN (
+	SELECT size FROM file_info
+	WHERE is_dir = 0 AND size >= ?`
+
+var TABLE_QUERY_SIZE_DUP_GROUP_SQL = `
+	GROUP BY size HAVING COUNT(*) > 1
+)
+ORDER BY size DESC, path`
+
+// HashGet returns the cached hashes of path, empty when the file changed
+// since they were computed.
+func (s *SQLiteDB) HashGet(path string, modTime time.Time, size int64) (string, string) {
+	var partial, full string
+	err := s.rdb.QueryRow(TABLE_HASH_GET_SQL, path, modTime.UnixNano(), size).Scan(&partial, &full)
+	if err != nil && err != sql.ErrNoRows {
+		logs.Warning("get hash %s failed, %s", path, err.Error())
+	}
+	return partial, full
+}
+
+func (s *SQLiteDB) HashSet(path string, modTime time.Time, size int64, partial string, full string) {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.db.Exec(TABLE_HASH_SET_SQL, path, modTime.UnixNano(), size, partial, full)
+	if err != nil {
+		logs.Warning("set hash %s failed, %s", path, err.Error())
+	}
+}
+
+// HashPrune drops the cached hashes of files no longer in the index.
+func (s *SQLiteDB) HashPrune() {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	result, err := s.db.Exec(TABLE_HASH_PRUNE_SQL)
+	if err != nil {
+		logs.Warning("prune hash failed, %s", err.Error())
+		return
+	}
+	cnt, _ := result.RowsAffected()
+	logs.Info("prune %d stale hashes", cnt)
+}
+
+// SizeDuplicates returns the files sharing their size with another file of
+// at least minSize bytes, largest first.
+func (s *SQLiteDB) SizeDuplicates(minSize int64, scopes ...string) ([]FileInfo, error) {
+	where, whereArgs := scopeWhere(scopes)
+
+	args := append([]interface{}{minSize}, whereArgs...)
+	args = append(args, minSize)
+	args = append(args, whereArgs...)
+
+	return s.queryRows(TABLE_QUERY_SIZE_DUP_SQL+where+TABLE_QUERY_SIZE_DUP_IN_SQL+where+TABLE_QUERY_SIZE_DUP_GROUP_SQL, args...)
+}
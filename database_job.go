@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var TABLE_JOB_SAVE_SQL = `
+INSERT INTO job_history (id, kind, target, state, current, total, message, result, error, start_time, end_time)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+state = excluded.state, current = excluded.current, total = excluded.total, message = excluded.message,
+result = excluded.result, error = excluded.error, end_time = excluded.end_time`
+
+var TABLE_JOB_INTERRUPT_SQL = `
+UPDATE job_history SET state = 'interrupted', end_time = ? WHERE state = 'running'`
+
+var TABLE_JOB_TRIM_SQL = `
+DELETE FROM job_history WHERE id NOT IN (
+	SELECT id FROM job_history ORDER BY start_time DESC LIMIT ?
+)`
+
+var TABLE_JOB_QUERY_SQL = `
+SELECT id, kind, target, state, current, total, message, result, error, start_time, end_time
+FROM job_history`
+
+func (s *SQLiteDB) JobSave(job Job) {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var endTime string
+	if !job.EndTime.IsZero() {
+		endTime = job.EndTime.Format(time.RFC3339Nano)
+	}
+
+	_, err := s.db.Exec(TABLE_JOB_SAVE_SQL, job.ID, job.Kind, job.Target, job.State,
+		job.Current, job.Total, job.Message, job.Result, job.Error,
+		job.StartTime.Format(time.RFC3339Nano), endTime)
+	if err != nil {
+		logs.Warning("save job %s failed, %s", job.ID, err.Error())
+	}
+}
+
+// JobInterrupt marks the jobs left running by a previous process.
+func (s *SQLiteDB) JobInterrupt(now time.Time) int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	result, err := s.db.Exec(TABLE_JOB_INTERRUPT_SQL, now.Format(time.RFC3339Nano))
+	if err != nil {
+		logs.Warning("interrupt jobs failed, %s", err.Error())
+		return 0
+	}
+	cnt, _ := result.RowsAffected()
+	return cnt
+}
+
+func (s *SQLiteDB) JobTrim(keep int) {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.db.Exec(TABLE_JOB_TRIM_SQL, keep)
+	if err != nil {
+		logs.Warning("trim job history failed, %s", err.Error())
+	}
+}
+
+func (s *SQLiteDB) JobGet(id string) (Job, error) {
+	jobs, err := s.jobQuery(TABLE_JOB_QUERY_SQL+"\nWHERE id = ?", id)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(jobs) == 0 {
+		return Job{}, fmt.Errorf("job %s not found", id)
+	}
+	return jobs[0], nil
+}
+
+func (s *SQLiteDB) JobList(state string, kind string, limit int) ([]Job, error) {
+	query := TABLE_JOB_QUERY_SQL + "\nWHERE 1 = 1"
+	args := make([]interface{}, 0)
+	if state != "" {
+		query += " AND state = ?"
+		args = append(args, state)
+	}
+	if kind != "" {
+		query += " AND kind = ?"
+		args = append(args, kind)
+	}
+	query += "\nORDER BY start_time DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return s.jobQuery(query, args...)
+}
+
+func (s *SQLiteDB) jobQuery(query string, args ...interface{}) ([]Job, error) {
+	rows, err := s.rdb.Query(query, args...)
+	if err != nil {
+		logs.Warning("query job sql failed, %s", err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	output := make([]Job, 0)
+
+	for rows.Next() {
+		var job Job
+		var startTime, endTime string
+
+		err := rows.Scan(&job.ID, &job.Kind, &job.Target, &job.State, &job.Current, &job.Total,
+			&job.Message, &job.Result, &job.Error, &startTime, &endTime)
+		if err != nil {
+			logs.Warning("find error during scan job row: %v", err)
+			continue
+		}
+		job.StartTime, _ = time.Parse(time.RFC3339Nano, startTime)
+		if endTime != "" {
+			job.EndTime, _ = time.Parse(time.RFC3339Nano, endTime)
+		}
+		output = append(output, job)
+	}
+	if err := rows.Err(); err != nil {
+		logs.Warning("find error during job iteration: %v", err)
+	}
+
+	return output, nil
+}
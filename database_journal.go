@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var JOURNAL_PRUNE_INTERVAL = time.Hour
+
+var (
+	CHANGE_ADD    = "add"
+	CHANGE_MODIFY = "modify"
+	CHANGE_REMOVE = "remove"
+	CHANGE_RENAME = "rename"
+	CHANGE_RESCAN = "rescan" // the index under the path was rewritten in bulk
+)
+
+var TABLE_JOURNAL_INSERT_SQL = `
+INSERT INTO change_journal (time, event, path, old_path, is_dir, size)
+VALUES (?, ?, ?, ?, ?, ?)`
+
+var TABLE_JOURNAL_PRUNE_TIME_SQL = `
+DELETE FROM change_journal WHERE time < ?`
+
+var TABLE_JOURNAL_PRUNE_ROWS_SQL = `
+DELETE FROM change_journal WHERE seq <= (SELECT MAX(seq) FROM change_journal) - ?`
+
+var TABLE_JOURNAL_QUERY_SQL = `
+SELECT seq, time, event, path, old_path, is_dir, size FROM change_journal
+WHERE time >= ? AND time <= ?`
+
+var TABLE_JOURNAL_AFTER_SQL = `
+SELECT seq, time, event, path, old_path, is_dir, size FROM change_journal
+WHERE seq > ?
+ORDER BY seq
+LIMIT ?`
+
+var TABLE_JOURNAL_BOUNDS_SQL = `
+SELECT
+	(SELECT IFNULL(MIN(seq), 0) FROM change_journal),
+	(SELECT IFNULL(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'change_journal'),
+	(SELECT IFNULL(MAX(seq), 0) FROM change_journal WHERE event = 'rescan')`
+
+type Change struct {
+	Seq     int64     `json:"seq"`
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"` // add, modify, remove, rename, rescan
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+}
+
+type ChangeFilter struct {
+	Since   time.Time
+	Until   time.Time
+	Events  []string
+	Pattern string // glob or substring of the path
+	Scopes  []string
+	Limit   int
+}
+
+// JournalRetention sets how long and how many changes the journal keeps,
+// 0 keeps them forever, and prunes the journal.
+func (s *SQLiteDB) JournalRetention(days int, rows int) {
+	s.Lock()
+	s.journalDays, s.journalRows = days, rows
+	s.Unlock()
+
+	s.JournalPrune()
+}
+
+func (s *SQLiteDB) JournalPrune() {
+	if s.readonly {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.journalPrune(s.db)
+}
+
+func (s *SQLiteDB) journalPrune(db sqlExecer) {
+	s.journalPruneTime = time.Now()
+
+	if s.journalDays > 0 {
+		before := time.Now().AddDate(0, 0, -s.journalDays).UnixNano()
+		_, err := db.Exec(TABLE_JOURNAL_PRUNE_TIME_SQL, before)
+		if err != nil {
+			logs.Warning("prune change journal by time failed, %s", err.Error())
+		}
+	}
+	if s.journalRows > 0 {
+		_, err := db.Exec(TABLE_JOURNAL_PRUNE_ROWS_SQL, s.journalRows)
+		if err != nil {
+			logs.Warning("prune change journal by rows failed, %s", err.Error())
+		}
+	}
+}
+
+// journal records an applied file change, a rename is recorded once with
+// both paths when its new name arrives. Called with the lock held.
+func (s *SQLiteDB) journal(db sqlExecer, event uint32, file FileInfo) {
+	now := time.Now()
+
+	if s.renameOld != nil && event != FILE_RENAME_NEW {
+		journalAdd(db, now, CHANGE_REMOVE, s.renameOld.Path, "", *s.renameOld)
+		s.renameOld = nil
+	}
+
+	switch event {
+	case FILE_ADD:
+		journalAdd(db, now, CHANGE_ADD, file.Path, "", file)
+	case FILE_MODIFIED:
+		journalAdd(db, now, CHANGE_MODIFY, file.Path, "", file)
+	case FILE_REMOVE:
+		journalAdd(db, now, CHANGE_REMOVE, file.Path, "", file)
+	case FILE_RENAME_OLD:
+		s.renameOld = &file
+	case FILE_RENAME_NEW:
+		if s.renameOld != nil {
+			journalAdd(db, now, CHANGE_RENAME, file.Path, s.renameOld.Path, file)
+			s.renameOld = nil
+		} else {
+			journalAdd(db, now, CHANGE_ADD, file.Path, "", file)
+		}
+	case FILE_RESCAN:
+		journalAdd(db, now, CHANGE_RESCAN, file.Path, "", file)
+	}
+
+	if time.Since(s.journalPruneTime) > JOURNAL_PRUNE_INTERVAL {
+		s.journalPrune(db)
+	}
+}
+
+func journalAdd(db sqlExecer, now time.Time, event string, path string, oldPath string, file FileInfo) {
+	_, err := db.Exec(TABLE_JOURNAL_INSERT_SQL, now.UnixNano(), event, path, oldPath, file.IsDir, file.Size)
+	if err != nil {
+		logs.Warning("insert change journal %s failed, %s", path, err.Error())
+	}
+}
+
+// Changes returns the journal entries matching the filter, newest first.
+func (s *SQLiteDB) Changes(filter ChangeFilter) ([]Change, error) {
+	until := filter.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	query := TABLE_JOURNAL_QUERY_SQL
+	args := []interface{}{filter.Since.UnixNano(), until.UnixNano()}
+
+	if len(filter.Events) > 0 {
+		query += "\nAND event IN (?" + strings.Repeat(", ?", len(filter.Events)-1) + ")"
+		for _, v := range filter.Events {
+			args = append(args, v)
+		}
+	}
+
+	if filter.Pattern != "" {
+		if IsGlobChar(filter.Pattern) {
+			query += "\nAND (path GLOB ? OR old_path GLOB ?)"
+			args = append(args, filter.Pattern, filter.Pattern)
+		} else {
+			like := "%" + LikeEscape(filter.Pattern) + "%"
+			query += "\nAND (path LIKE ? ESCAPE '^' OR old_path LIKE ? ESCAPE '^')"
+			args = append(args, like, like)
+		}
+	}
+
+	where, whereArgs := scopeWhere(filter.Scopes)
+	query += where
+	args = append(args, whereArgs...)
+
+	query += "\nORDER BY seq DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	return s.changeQuery(query, args...)
+}
+
+func (s *SQLiteDB) changeQuery(query string, args ...interface{}) ([]Change, error) {
+	rows, err := s.rdb.Query(query, args...)
+	if err != nil {
+		logs.Warning("query change journal failed, %s", err.Error())
+		return nil, fmt.Errorf("query change journal failed, %s", err.Error())
+	}
+	defer rows.Close()
+
+	output := make([]Change, 0)
+	for rows.Next() {
+		var change Change
+		var timestamp int64
+		var isDir int
+		err := rows.Scan(&change.Seq, &timestamp, &change.Event, &change.Path, &change.OldPath, &isDir, &change.Size)
+		if err != nil {
+			logs.Warning("find error during scan change row: %v", err)
+			continue
+		}
+		change.Time = time.Unix(0, timestamp)
+		change.IsDir = isDir > 0
+		output = append(output, change)
+	}
+	return output, rows.Err()
+}
+
+// ChangesAfter returns the journal entries following the sequence number
+// after, oldest first.
+func (s *SQLiteDB) ChangesAfter(after int64, limit int) ([]Change, error) {
+	return s.changeQuery(TABLE_JOURNAL_AFTER_SQL, after, limit)
+}
+
+// JournalBounds returns the oldest sequence number kept in the journal, 0
+// when it is empty, the last sequence number ever assigned and the one of
+// the last rescan kept.
+func (s *SQLiteDB) JournalBounds() (int64, int64, int64, error) {
+	var oldest, last, rescan int64
+	err := s.rdb.QueryRow(TABLE_JOURNAL_BOUNDS_SQL).Scan(&oldest, &last, &rescan)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("query change journal bounds failed, %s", err.Error())
+	}
+	return oldest, last, rescan, nil
+}
+
+// Rescanned records that the index under root was rewritten in bulk by a
+// scan, a prune or a drop, the entries written were not journaled one by
+// one. An empty root stands for the whole index.
+func (s *SQLiteDB) Rescanned(root string) {
+	if s.readonly {
+		return
+	}
+
+	notify := &FileNotify{Event: FILE_RESCAN, File: FileInfo{Path: root, IsDir: 1}}
+
+	s.Lock()
+	s.journal(s.db, notify.Event, notify.File)
+	s.Unlock()
+
+	s.notifyListeners(notify)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var TABLE_SCHEMA_CREATE_SQL = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied TEXT NOT NULL
+);`
+
+var TABLE_SCHEMA_VERSION_SQL = `
+SELECT IFNULL(MAX(version), 0) FROM schema_version`
+
+var TABLE_SCHEMA_INSERT_SQL = `
+INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)`
+
+var TABLE_EXIST_SQL = `
+SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+
+var TABLE_FILE_INFO_V2_SQL = `
+CREATE TABLE file_info_v2 (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	is_dir INTEGER NOT NULL,
+	path TEXT NOT NULL UNIQUE,
+	ext TEXT NOT NULL,
+	drive TEXT NOT NULL,
+	mod_time INTEGER NOT NULL,
+	size INTEGER NOT NULL
+);`
+
+// TABLE_FILE_INFO_V2_COPY_SQL keeps the milliseconds of the text times,
+// 2440587.5 is the julian day of the unix epoch. Times beyond the int64
+// nanoseconds, such as the 1601 of a zero FILETIME, become 0.
+var TABLE_FILE_INFO_V2_COPY_SQL = `
+INSERT INTO file_info_v2 (id, name, is_dir, path, ext, drive, mod_time, size)
+SELECT id, name, is_dir, path, ext, drive,
+	IFNULL(CASE WHEN julianday(mod_time) BETWEEN 2333836 AND 2547339
+		THEN CAST(ROUND((julianday(mod_time) - 2440587.5) * 86400000) AS INTEGER) * 1000000 END, 0), size
+FROM file_info`
+
+// migration upgrades the schema from the previous version. A released
+// migration must never change, later changes go into a new one.
+type migration struct {
+	version int
+	name    string
+	sql     []string
+}
+
+var migrations = []migration{
+	{1, "baseline", []string{
+		`CREATE TABLE IF NOT EXISTS file_info (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			is_dir INTEGER NOT NULL,
+			path TEXT NOT NULL UNIQUE,
+			ext TEXT NOT NULL,
+			drive TEXT NOT NULL,
+			mod_time TEXT NOT NULL,
+			size INTEGER NOT NULL
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_file_info_name ON file_info (name, ext)",
+		`CREATE TABLE IF NOT EXISTS index_meta (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS job_history (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL,
+			state TEXT NOT NULL,
+			current INTEGER NOT NULL,
+			total INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			result TEXT NOT NULL,
+			error TEXT NOT NULL,
+			start_time TEXT NOT NULL,
+			end_time TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS file_hash (
+			path TEXT PRIMARY KEY,
+			mod_time TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			partial TEXT NOT NULL,
+			full TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS dir_usage (
+			path TEXT PRIMARY KEY,
+			size INTEGER NOT NULL,
+			files INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS change_journal (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			time INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			path TEXT NOT NULL,
+			old_path TEXT NOT NULL,
+			is_dir INTEGER NOT NULL,
+			size INTEGER NOT NULL
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_change_journal_time ON change_journal (time)",
+	}},
+	{2, "mod_time_ns", []string{
+		TABLE_FILE_INFO_V2_SQL,
+		TABLE_FILE_INFO_V2_COPY_SQL,
+		"DROP TABLE file_info",
+		"ALTER TABLE file_info_v2 RENAME TO file_info",
+		"CREATE INDEX idx_file_info_name ON file_info (name, ext)",
+		"CREATE INDEX idx_file_info_mod_time ON file_info (mod_time)",
+		"CREATE INDEX idx_file_info_size ON file_info (size)",
+		"CREATE INDEX idx_file_info_ext ON file_info (ext COLLATE NOCASE)",
+		"CREATE INDEX idx_file_info_path ON file_info (path COLLATE NOCASE)",
+	}},
+	{3, "file_meta", []string{
+		"ALTER TABLE file_info ADD COLUMN btime INTEGER",
+		"ALTER TABLE file_info ADD COLUMN ctime INTEGER",
+		"ALTER TABLE file_info ADD COLUMN atime INTEGER",
+		"ALTER TABLE file_info ADD COLUMN mode INTEGER",
+		"ALTER TABLE file_info ADD COLUMN uid TEXT",
+		"ALTER TABLE file_info ADD COLUMN gid TEXT",
+		"ALTER TABLE file_info ADD COLUMN owner TEXT",
+		"ALTER TABLE file_info ADD COLUMN grp TEXT",
+		"ALTER TABLE file_info ADD COLUMN inode INTEGER",
+		"ALTER TABLE file_info ADD COLUMN device INTEGER",
+		"ALTER TABLE file_info ADD COLUMN nlink INTEGER",
+		"ALTER TABLE file_info ADD COLUMN link_target TEXT",
+		"CREATE INDEX idx_file_info_owner ON file_info (owner COLLATE NOCASE)",
+		"CREATE INDEX idx_file_info_uid ON file_info (uid)",
+		"CREATE INDEX idx_file_info_inode ON file_info (device, inode)",
+	}},
+	{4, "file_class", []string{
+		"ALTER TABLE file_info ADD COLUMN mime TEXT",
+		"ALTER TABLE file_info ADD COLUMN category TEXT",
+		"CREATE INDEX idx_file_info_mime ON file_info (mime)",
+		"CREATE INDEX idx_file_info_category ON file_info (category)",
+	}},
+	{5, "hash_mod_time_ns", []string{
+		// the hashes were keyed by RFC3339 text, they are computed again
+		"DROP TABLE file_hash",
+		`CREATE TABLE file_hash (
+			path TEXT PRIMARY KEY,
+			mod_time INTEGER NOT NULL,
+			size INTEGER NOT NULL,
+			partial TEXT NOT NULL,
+			full TEXT NOT NULL
+		)`,
+	}},
+	{6, "dir_usage_nocase", []string{
+		"CREATE INDEX idx_dir_usage_path ON dir_usage (path COLLATE NOCASE)",
+	}},
+	{7, "journal_rescan", []string{
+		"CREATE INDEX idx_change_journal_rescan ON change_journal (seq) WHERE event = 'rescan'",
+	}},
+}
+
+func SchemaLatest() int {
+	return migrations[len(migrations)-1].version
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var exist int
+	err := db.QueryRow(TABLE_EXIST_SQL, "schema_version").Scan(&exist)
+	if err != nil || exist == 0 {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRow(TABLE_SCHEMA_VERSION_SQL).Scan(&version)
+	return version, err
+}
+
+// schemaCheck refuses a database written by a newer version, a read-only
+// database can not be upgraded and is used as it is.
+func schemaCheck(db *sql.DB) (int, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("query schema version failed, %s", err.Error())
+	}
+	if version > SchemaLatest() {
+		return version, fmt.Errorf("index schema version %d is newer than %d, upgrade %s",
+			version, SchemaLatest(), APPLICATION_NAME)
+	}
+	return version, nil
+}
+
+// migrate brings the schema of db to the latest version in one transaction,
+// an existing database is backed up next to it first.
+func migrate(db *sql.DB, path string) error {
+	version, err := schemaCheck(db)
+	if err != nil {
+		return err
+	}
+	if version == SchemaLatest() {
+		return nil
+	}
+
+	var exist int
+	err = db.QueryRow(TABLE_EXIST_SQL, "file_info").Scan(&exist)
+	if err != nil {
+		return fmt.Errorf("query file_info table failed, %s", err.Error())
+	}
+	if exist > 0 {
+		err = schemaBackup(db, path, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction failed, %s", err.Error())
+	}
+
+	_, err = tx.Exec(TABLE_SCHEMA_CREATE_SQL)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("create schema table failed, %s", err.Error())
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		for _, query := range m.sql {
+			_, err = tx.Exec(query)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("schema migration %d %s failed, %s", m.version, m.name, err.Error())
+			}
+		}
+		_, err = tx.Exec(TABLE_SCHEMA_INSERT_SQL, m.version, m.name, time.Now().Format(time.RFC3339))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("insert schema version %d failed, %s", m.version, err.Error())
+		}
+		logs.Info("schema migration %d %s", m.version, m.name)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit schema migration failed, %s", err.Error())
+	}
+
+	logs.Info("index schema upgraded from version %d to %d", version, SchemaLatest())
+	return nil
+}
+
+// schemaBackup copies the database to <file>.v<version>.bak before it is
+// upgraded, the last backup of a version is kept.
+func schemaBackup(db *sql.DB, path string, version int) error {
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	os.Remove(backup)
+
+	_, err := db.Exec("VACUUM INTO ?", filepath.Clean(backup))
+	if err != nil {
+		return fmt.Errorf("backup index to %s failed, %s", backup, err.Error())
+	}
+	logs.Info("index backup to %s", backup)
+	return nil
+}
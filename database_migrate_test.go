@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TABLE_V0_CREATE_SQL is the file_info table before the schema was versioned,
+// mod_time was stored as RFC3339 text or in the format of the sqlite3 driver.
+var TABLE_V0_CREATE_SQL = `
+CREATE TABLE file_info (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	is_dir INTEGER NOT NULL,
+	path TEXT NOT NULL UNIQUE,
+	ext TEXT NOT NULL,
+	drive TEXT NOT NULL,
+	mod_time TEXT NOT NULL,
+	size INTEGER NOT NULL
+);`
+
+func testOpen(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), DATABASE_FILE)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func TestMigrateV0(t *testing.T) {
+	tests := []struct {
+		path    string
+		modTime string
+		want    int64
+	}{
+		{`C:\a.txt`, "2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()},
+		{`C:\b.txt`, "2024-01-02T11:04:05+08:00", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()},
+		{`C:\c.txt`, "2023-07-01T00:00:00-05:00", time.Date(2023, 7, 1, 5, 0, 0, 0, time.UTC).UnixNano()},
+		{`C:\d.txt`, "not a time", 0},
+		{`C:\e.txt`, "2024-01-02T03:04:05.123456789Z", time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC).UnixNano()},
+		{`C:\f.txt`, "2024-01-02 11:04:05.5+08:00", time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC).UnixNano()},
+		{`C:\g.txt`, "1601-01-01T00:00:00Z", 0},
+	}
+
+	db, path := testOpen(t)
+	_, err := db.Exec(TABLE_V0_CREATE_SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		_, err = db.Exec("INSERT INTO file_info (name, is_dir, path, ext, drive, mod_time, size) VALUES (?, 0, ?, '.txt', 'C:\\', ?, ?)",
+			filepath.Base(tt.path), tt.path, tt.modTime, i*100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = migrate(db, path)
+	if err != nil {
+		t.Fatalf("migrate failed, %s", err.Error())
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil || version != SchemaLatest() {
+		t.Fatalf("schema version %d %v, want %d", version, err, SchemaLatest())
+	}
+	_, err = os.Stat(fmt.Sprintf("%s.v0.bak", path))
+	if err != nil {
+		t.Errorf("v0 backup missing, %s", err.Error())
+	}
+
+	for i, tt := range tests {
+		var modTime, size int64
+		var mime sql.NullString
+		err = db.QueryRow("SELECT mod_time, size, mime FROM file_info WHERE path = ?", tt.path).Scan(&modTime, &size, &mime)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.path, err.Error())
+		}
+		if modTime != tt.want {
+			t.Errorf("%s: mod_time %d from %q, want %d", tt.path, modTime, tt.modTime, tt.want)
+		}
+		if size != int64(i*100) || mime.Valid {
+			t.Errorf("%s: size %d mime %v, want %d and no mime", tt.path, size, mime, i*100)
+		}
+	}
+
+	var hashType string
+	err = db.QueryRow("SELECT type FROM pragma_table_info('file_hash') WHERE name = 'mod_time'").Scan(&hashType)
+	if err != nil || hashType != "INTEGER" {
+		t.Errorf("file_hash mod_time %q %v, want INTEGER", hashType, err)
+	}
+
+	err = migrate(db, path)
+	if err != nil {
+		t.Fatalf("second migrate failed, %s", err.Error())
+	}
+}
+
+func TestMigrateNew(t *testing.T) {
+	db, path := testOpen(t)
+
+	err := migrate(db, path)
+	if err != nil {
+		t.Fatalf("migrate failed, %s", err.Error())
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&count)
+	if err != nil || count != len(migrations) {
+		t.Errorf("%d migrations recorded %v, want %d", count, err, len(migrations))
+	}
+	_, err = os.Stat(fmt.Sprintf("%s.v0.bak", path))
+	if !os.IsNotExist(err) {
+		t.Errorf("new database was backed up, %v", err)
+	}
+
+	_, err = db.Exec("INSERT INTO file_info (name, is_dir, path, ext, drive, mod_time, size, owner, mime, category) VALUES ('a', 0, 'C:\\a', '', 'C:\\', 1, 0, 'me', 'text/plain', 'document')")
+	if err != nil {
+		t.Errorf("insert into the latest schema failed, %s", err.Error())
+	}
+}
+
+func TestMigrateNewer(t *testing.T) {
+	db, path := testOpen(t)
+
+	err := migrate(db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(TABLE_SCHEMA_INSERT_SQL, SchemaLatest()+1, "future", time.Now().Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrate(db, path)
+	if err == nil {
+		t.Errorf("migrate of a newer schema succeeded")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryFilterParseTerms(t *testing.T) {
+	tests := []struct {
+		keyword string
+		want    QueryFilter
+	}{
+		{"report", QueryFilter{Keyword: "report"}},
+		{"type:image", QueryFilter{Category: "image"}},
+		{"type:Image/*", QueryFilter{Mime: "image/"}},
+		{"type:application/pdf report", QueryFilter{Keyword: "report", Mime: "application/pdf"}},
+		{"category:Code main", QueryFilter{Keyword: "main", Category: "code"}},
+		{"group:documents GROUP:images", QueryFilter{Groups: []string{"documents", "images"}}},
+		{"a type: b", QueryFilter{Keyword: "a type: b"}},
+		{"c:\\users  x", QueryFilter{Keyword: "c:\\users  x"}},
+		{"*.go  category:code", QueryFilter{Keyword: "*.go", Category: "code"}},
+	}
+
+	for _, tt := range tests {
+		got := QueryFilter{Keyword: tt.keyword}.parseTerms()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTerms(%q) = %+v, want %+v", tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestQueryFilterWhere(t *testing.T) {
+	after := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter QueryFilter
+		where  string
+		args   []interface{}
+	}{
+		{"empty", QueryFilter{}, "", []interface{}{}},
+		{"like", QueryFilter{Keyword: "report"}, "\nAND name LIKE ?", []interface{}{"%report%"}},
+		{"glob", QueryFilter{Keyword: "*.go"}, "\nAND name GLOB ?", []interface{}{"*.go"}},
+		{"ext", QueryFilter{Ext: "PDF"}, "\nAND ext = ? COLLATE NOCASE", []interface{}{".PDF"}},
+		{"exts", QueryFilter{Exts: []string{".doc", "txt"}},
+			"\nAND ext COLLATE NOCASE IN (?, ?)", []interface{}{".doc", ".txt"}},
+		{"size", QueryFilter{MinSize: 10, MaxSize: 20},
+			"\nAND size >= ?\nAND size <= ?", []interface{}{int64(10), int64(20)}},
+		{"modified", QueryFilter{ModifiedAfter: after, ModifiedBefore: after.Add(time.Second)},
+			"\nAND mod_time >= ?\nAND mod_time < ?", []interface{}{after.UnixNano(), after.Add(time.Second).UnixNano()}},
+		{"category term", QueryFilter{Keyword: "type:image cat"},
+			"\nAND name LIKE ?\nAND category = ?", []interface{}{"%cat%", "image"}},
+		{"mime prefix", QueryFilter{Mime: "image/"},
+			"\nAND mime >= ? AND mime < ?", []interface{}{"image/", "image0"}},
+		{"mime term", QueryFilter{Keyword: "type:text/plain"}, "\nAND mime = ?", []interface{}{"text/plain"}},
+		{"owner", QueryFilter{Owner: "alice"},
+			"\nAND (owner = ? COLLATE NOCASE OR uid = ?)", []interface{}{"alice", "alice"}},
+		{"order name", QueryFilter{Order: "name"}, "\nORDER BY name", []interface{}{}},
+		{"order recent", QueryFilter{Order: "recent"}, "\nORDER BY mod_time DESC", []interface{}{}},
+		{"order largest", QueryFilter{MinSize: 1, Order: "largest"},
+			"\nAND size >= ?\nORDER BY size DESC", []interface{}{int64(1)}},
+	}
+
+	for _, tt := range tests {
+		where, args := tt.filter.where()
+		if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: where() = %q %v, want %q %v", tt.name, where, args, tt.where, tt.args)
+		}
+	}
+}
+
+func TestScopeWhere(t *testing.T) {
+	db, _ := testOpen(t)
+	_, err := db.Exec("CREATE TABLE file_info (path TEXT NOT NULL UNIQUE)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE INDEX idx_file_info_path ON file_info (path COLLATE NOCASE)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{
+		`C:\Users`, `C:\Users\a.txt`, `C:\users\B\c.txt`, `C:\Users2`, `C:\Users2\d.txt`,
+		`C:\Users]`, `C:\Users-old\e.txt`, `D:\Users\f.txt`, `C:\Windows`,
+	}
+	for _, path := range paths {
+		_, err = db.Exec("INSERT INTO file_info (path) VALUES (?)", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		scopes []string
+		want   []string
+	}{
+		{nil, paths},
+		{[]string{`C:\Users`}, []string{`C:\Users`, `C:\Users\a.txt`, `C:\users\B\c.txt`}},
+		{[]string{`c:\USERS\`}, []string{`C:\Users`, `C:\Users\a.txt`, `C:\users\B\c.txt`}},
+		{[]string{`C:\Users\b`}, []string{`C:\users\B\c.txt`}},
+		{[]string{`C:\Users2`, `D:\`}, []string{`C:\Users2`, `C:\Users2\d.txt`, `D:\Users\f.txt`}},
+		{[]string{`E:\`}, nil},
+	}
+
+	for _, tt := range tests {
+		where, args := scopeWhere(tt.scopes)
+		rows, err := db.Query("SELECT path FROM file_info WHERE 1 = 1"+where+" ORDER BY rowid", args...)
+		if err != nil {
+			t.Fatalf("%v: %s", tt.scopes, err.Error())
+		}
+		var got []string
+		for rows.Next() {
+			var path string
+			rows.Scan(&path)
+			got = append(got, path)
+		}
+		rows.Close()
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("scopeWhere(%v) = %v, want %v", tt.scopes, got, tt.want)
+		}
+	}
+}
+
+func TestUsageDirsWhere(t *testing.T) {
+	db, _ := testOpen(t)
+	_, err := db.Exec("CREATE TABLE dir_usage (path TEXT PRIMARY KEY, size INTEGER NOT NULL, files INTEGER NOT NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{
+		`C:\`, `C:\Users`, `C:\Users\a`, `C:\users\A\b`, `C:\Users2`, `C:\Users2\c`, `D:\Users`,
+	}
+	for _, path := range paths {
+		_, err = db.Exec("INSERT INTO dir_usage (path, size, files) VALUES (?, 0, 0)", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		depth  int
+		scopes []string
+		want   []string
+	}{
+		{0, nil, paths},
+		{0, []string{`C:\`}, []string{`C:\Users`, `C:\Users\a`, `C:\users\A\b`, `C:\Users2`, `C:\Users2\c`}},
+		{1, []string{`C:\`}, []string{`C:\Users`, `C:\Users2`}},
+		{1, []string{`c:\USERS`}, []string{`C:\Users\a`}},
+		{2, []string{`C:\Users\`}, []string{`C:\Users\a`, `C:\users\A\b`}},
+		{1, []string{`C:\Users2`, `D:\`}, []string{`C:\Users2\c`, `D:\Users`}},
+	}
+
+	for _, tt := range tests {
+		where, args := usageDirsWhere(tt.depth, tt.scopes)
+		rows, err := db.Query("SELECT path FROM dir_usage WHERE 1 = 1"+where+" ORDER BY rowid", args...)
+		if err != nil {
+			t.Fatalf("%v: %s", tt.scopes, err.Error())
+		}
+		var got []string
+		for rows.Next() {
+			var path string
+			rows.Scan(&path)
+			got = append(got, path)
+		}
+		rows.Close()
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("usageDirsWhere(%d, %v) = %v, want %v", tt.depth, tt.scopes, got, tt.want)
+		}
+	}
+}
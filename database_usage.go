@@ -0,0 +1,384 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var TABLE_USAGE_ADD_SQL = `
+INSERT INTO dir_usage (path, size, files) VALUES (?, ?, ?)
+ON CONFLICT(path) DO UPDATE SET
+size = size + excluded.size, files = files + excluded.files`
+
+var TABLE_USAGE_GET_SQL = `
+SELECT size, files FROM dir_usage WHERE path = ? COLLATE NOCASE`
+
+var TABLE_USAGE_CASE_SQL = `
+SELECT path FROM dir_usage WHERE path = ? COLLATE NOCASE LIMIT 1`
+
+var TABLE_USAGE_DELETE_SQL = `
+DELETE FROM dir_usage WHERE path = ? COLLATE NOCASE OR path LIKE ? ESCAPE '^'`
+
+var TABLE_USAGE_QUERY_SQL = `
+SELECT path, size, files FROM dir_usage
+WHERE 1 = 1`
+
+var TABLE_USAGE_FILES_SQL = `
+SELECT path, size FROM file_info
+WHERE is_dir = 0`
+
+var TABLE_USAGE_LARGEST_SQL = `
+SELECT path, size FROM file_info
+WHERE is_dir = 0`
+
+var TABLE_USAGE_EXT_SQL = `
+SELECT ext, COUNT(*), SUM(size) FROM file_info
+WHERE is_dir = 0`
+
+var TABLE_FILE_GET_SQL = `
+SELECT is_dir, size FROM file_info WHERE path = ?`
+
+type UsageEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files,omitempty"`
+}
+
+type ExtUsage struct {
+	Ext   string `json:"ext"`
+	Files int64  `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// UsageAncestors returns the directories containing path, nearest first,
+// up to the drive root.
+func UsageAncestors(path string) []string {
+	output := make([]string, 0)
+	dir := filepath.Dir(path)
+	for {
+		output = append(output, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return output
+}
+
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func usageAdd(db sqlExecer, dirs []string, size int64, files int64) {
+	for _, dir := range dirs {
+		_, err := db.Exec(TABLE_USAGE_ADD_SQL, dir, size, files)
+		if err != nil {
+			logs.Warning("update dir usage %s failed, %s", dir, err.Error())
+			return
+		}
+	}
+}
+
+func usageGet(db sqlExecer, path string) (int64, int64) {
+	var size, files int64
+	err := db.QueryRow(TABLE_USAGE_GET_SQL, path).Scan(&size, &files)
+	if err != nil && err != sql.ErrNoRows {
+		logs.Warning("get dir usage %s failed, %s", path, err.Error())
+	}
+	return size, files
+}
+
+// fileGet returns the indexed kind and size of path, ok is false when the
+// path is not indexed.
+func fileGet(db sqlExecer, path string) (int, int64, bool) {
+	var isDir int
+	var size int64
+	err := db.QueryRow(TABLE_FILE_GET_SQL, path).Scan(&isDir, &size)
+	if err != nil {
+		return 0, 0, false
+	}
+	return isDir, size, true
+}
+
+// usageRemove takes an indexed entry out of the aggregates of its parents,
+// a directory takes its whole aggregate along.
+func usageRemove(db sqlExecer, path string, isDir int, size int64) {
+	if isDir == 0 {
+		usageAdd(db, UsageAncestors(path), -size, -1)
+		return
+	}
+
+	dirSize, dirFiles := usageGet(db, path)
+	if dirSize != 0 || dirFiles != 0 {
+		usageAdd(db, UsageAncestors(path), -dirSize, -dirFiles)
+	}
+	_, err := db.Exec(TABLE_USAGE_DELETE_SQL, path, LikeEscape(strings.TrimSuffix(path, string(filepath.Separator))+string(filepath.Separator))+"%")
+	if err != nil {
+		logs.Warning("delete dir usage %s failed, %s", path, err.Error())
+	}
+}
+
+// usageRebuild is a rebuild of the aggregates under root in progress, it is
+// dirty when a file under root was written since its files were read.
+type usageRebuild struct {
+	root  string
+	dirty bool
+}
+
+// USAGE_REBUILD_RETRY is how often the files are read again outside of the
+// writer lock when they changed meanwhile, the last read holds the lock.
+var USAGE_REBUILD_RETRY = 3
+
+// usageTouch marks the rebuilds the change of path falls into, called with
+// the writer lock held.
+func (s *SQLiteDB) usageTouch(path string) {
+	for rebuild := range s.rebuilds {
+		if PathWithin(path, rebuild.root) || PathWithin(rebuild.root, path) {
+			rebuild.dirty = true
+		}
+	}
+}
+
+// usageCollect sums the indexed files under root into the aggregates of
+// their directories, and returns root in the case of the index.
+func usageCollect(db *sql.DB, root string) (map[string]*UsageEntry, string, error) {
+	where, args := scopeWhere([]string{root})
+
+	rows, err := db.Query(TABLE_USAGE_FILES_SQL+where, args...)
+	if err != nil {
+		return nil, root, fmt.Errorf("query files under %s failed, %s", root, err.Error())
+	}
+	defer rows.Close()
+
+	usage := make(map[string]*UsageEntry)
+
+	for rows.Next() {
+		var path string
+		var size int64
+		if err := rows.Scan(&path, &size); err != nil {
+			continue
+		}
+		for _, dir := range UsageAncestors(path) {
+			last := strings.EqualFold(dir, root)
+			if last {
+				root = dir // the case of root in the index
+			}
+			entry, ok := usage[dir]
+			if !ok {
+				entry = &UsageEntry{Path: dir}
+				usage[dir] = entry
+			}
+			entry.Size += size
+			entry.Files++
+			if last {
+				break
+			}
+		}
+	}
+	return usage, root, rows.Err()
+}
+
+// usageSwap replaces the aggregates under root in one transaction, and moves
+// the aggregates of the parents of root by the difference. Called with the
+// writer lock held.
+func (s *SQLiteDB) usageSwap(root string, usage map[string]*UsageEntry) (*UsageEntry, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction failed, %s", err.Error())
+	}
+
+	if usage[root] == nil {
+		// no files are left under root, its case is kept by its aggregate
+		var path string
+		if tx.QueryRow(TABLE_USAGE_CASE_SQL, root).Scan(&path) == nil {
+			root = path
+		}
+		usage[root] = &UsageEntry{Path: root}
+	}
+
+	oldSize, oldFiles := usageGet(tx, root)
+
+	_, err = tx.Exec(TABLE_USAGE_DELETE_SQL, root, LikeEscape(strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))+"%")
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("delete dir usage %s failed, %s", root, err.Error())
+	}
+
+	for _, entry := range usage {
+		_, err = tx.Exec(TABLE_USAGE_ADD_SQL, entry.Path, entry.Size, entry.Files)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("insert dir usage %s failed, %s", entry.Path, err.Error())
+		}
+	}
+
+	if filepath.Dir(root) != root {
+		usageAdd(tx, UsageAncestors(root), usage[root].Size-oldSize, usage[root].Files-oldFiles)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("commit dir usage failed, %s", err.Error())
+	}
+	return usage[root], nil
+}
+
+// UsageRebuild computes the aggregates under root from the indexed files.
+// The files are read outside of the writer lock and the aggregates swapped
+// in a short transaction under it, the files are read again when one under
+// root was written in between.
+func (s *SQLiteDB) UsageRebuild(root string) error {
+	if s.readonly {
+		return fmt.Errorf("index is read-only")
+	}
+
+	rebuild := &usageRebuild{root: root}
+
+	s.Lock()
+	s.rebuilds[rebuild] = struct{}{}
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		delete(s.rebuilds, rebuild)
+		s.Unlock()
+	}()
+
+	for retry := 0; ; retry++ {
+		locked := retry >= USAGE_REBUILD_RETRY
+
+		s.Lock()
+		rebuild.dirty = false
+		if !locked {
+			s.Unlock()
+		}
+
+		db := s.rdb
+		if locked {
+			db = s.db
+		}
+		usage, path, err := usageCollect(db, root)
+
+		if !locked {
+			s.Lock()
+		}
+		if err == nil && rebuild.dirty && !locked {
+			s.Unlock()
+			logs.Info("dir usage %s changed while read, read again", root)
+			continue
+		}
+
+		var total *UsageEntry
+		if err == nil {
+			total, err = s.usageSwap(path, usage)
+		}
+		s.Unlock()
+
+		if err != nil {
+			return err
+		}
+		logs.Info("dir usage %s rebuilt, %d directories, %s in %d files",
+			total.Path, len(usage), ByteView(total.Size), total.Files)
+		return nil
+	}
+}
+
+func (s *SQLiteDB) UsageGet(path string) UsageEntry {
+	size, files := usageGet(s.rdb, path)
+	return UsageEntry{Path: path, Size: size, Files: files}
+}
+
+// UsageDirs returns the largest directories under the scopes, at most depth
+// levels below them when depth is above 0.
+func (s *SQLiteDB) UsageDirs(limit int, depth int, scopes ...string) ([]UsageEntry, error) {
+	where, args := usageDirsWhere(depth, scopes)
+	args = append(args, limit)
+
+	rows, err := s.rdb.Query(TABLE_USAGE_QUERY_SQL+where+"\nORDER BY size DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	output := make([]UsageEntry, 0)
+	for rows.Next() {
+		var entry UsageEntry
+		if err := rows.Scan(&entry.Path, &entry.Size, &entry.Files); err != nil {
+			continue
+		}
+		output = append(output, entry)
+	}
+	return output, rows.Err()
+}
+
+// usageDirsWhere selects the directories below the scopes, not the scopes
+// themselves, at most depth levels below them by the count of separators.
+func usageDirsWhere(depth int, scopes []string) (string, []interface{}) {
+	if len(scopes) == 0 {
+		return "", nil
+	}
+
+	clause := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	for _, scope := range scopes {
+		root := strings.TrimSuffix(scope, string(filepath.Separator))
+		where := "(path > ? COLLATE NOCASE AND path < ? COLLATE NOCASE"
+		args = append(args, root+string(filepath.Separator), root+"]")
+		if depth > 0 {
+			where += " AND length(path) - length(replace(path, ?, '')) <= ?"
+			args = append(args, string(filepath.Separator), strings.Count(root, string(filepath.Separator))+depth)
+		}
+		clause = append(clause, where+")")
+	}
+
+	return "\nAND (" + strings.Join(clause, " OR ") + ")", args
+}
+
+func (s *SQLiteDB) UsageFiles(limit int, scopes ...string) ([]UsageEntry, error) {
+	where, args := scopeWhere(scopes)
+	args = append(args, limit)
+
+	rows, err := s.rdb.Query(TABLE_USAGE_LARGEST_SQL+where+"\nORDER BY size DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	output := make([]UsageEntry, 0)
+	for rows.Next() {
+		var entry UsageEntry
+		if err := rows.Scan(&entry.Path, &entry.Size); err != nil {
+			continue
+		}
+		output = append(output, entry)
+	}
+	return output, rows.Err()
+}
+
+func (s *SQLiteDB) UsageExts(limit int, scopes ...string) ([]ExtUsage, error) {
+	where, args := scopeWhere(scopes)
+	args = append(args, limit)
+
+	rows, err := s.rdb.Query(TABLE_USAGE_EXT_SQL+where+"\nGROUP BY ext ORDER BY SUM(size) DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	output := make([]ExtUsage, 0)
+	for rows.Next() {
+		var entry ExtUsage
+		if err := rows.Scan(&entry.Ext, &entry.Files, &entry.Size); err != nil {
+			continue
+		}
+		output = append(output, entry)
+	}
+	return output, rows.Err()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var DUPLICATE_BLOCK_SIZE int64 = 64 * 1024
+var DUPLICATE_READ_SIZE = 1024 * 1024
+
+type DuplicateGroup struct {
+	Size   int64    `json:"size"`
+	Hash   string   `json:"sha256"`
+	Wasted int64    `json:"wasted"`
+	Files  []string `json:"files"`
+}
+
+type DuplicateReport struct {
+	Scopes     []string         `json:"scopes,omitempty"`
+	MinSize    int64            `json:"min_size"`
+	Candidates int              `json:"candidates"`
+	Hashed     int64            `json:"hashed_bytes"`
+	Wasted     int64            `json:"wasted"`
+	Groups     []DuplicateGroup `json:"groups"`
+}
+
+type hashFile struct {
+	path    string
+	info    os.FileInfo
+	partial string
+	full    string
+}
+
+// FindDuplicates groups the indexed files of at least minSize bytes by size,
+// then by a hash of their first and last blocks, then by their full SHA-256.
+// Hashes are cached in the file_hash table by path, mod time and size.
+func FindDuplicates(ctx context.Context, s *SQLiteDB, minSize int64, progress Progress, scopes ...string) (*DuplicateReport, error) {
+	if minSize < 1 {
+		minSize = 1
+	}
+
+	files, err := s.SizeDuplicates(minSize, scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DuplicateReport{
+		Scopes:     scopes,
+		MinSize:    minSize,
+		Candidates: len(files),
+		Groups:     make([]DuplicateGroup, 0),
+	}
+
+	done := 0
+	for i := 0; i < len(files); {
+		j := i
+		for j < len(files) && files[j].Size == files[i].Size {
+			j++
+		}
+
+		groups, err := report.sizeGroup(ctx, s, files[i].Size, files[i:j])
+		if err != nil {
+			return nil, err
+		}
+		report.Groups = append(report.Groups, groups...)
+
+		done += j - i
+		progress.Update(int64(done), int64(len(files)), files[i].Path)
+		i = j
+	}
+
+	for _, v := range report.Groups {
+		report.Wasted += v.Wasted
+	}
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Wasted > report.Groups[j].Wasted
+	})
+
+	if len(scopes) == 0 {
+		s.HashPrune()
+	}
+
+	logs.Info("find duplicates, %d candidates, %d groups, %s wasted, %s hashed",
+		len(files), len(report.Groups), ByteView(report.Wasted), ByteView(report.Hashed))
+
+	return report, nil
+}
+
+func (r *DuplicateReport) sizeGroup(ctx context.Context, s *SQLiteDB, size int64, files []FileInfo) ([]DuplicateGroup, error) {
+	candidates := make([]*hashFile, 0)
+	for _, file := range files {
+		info, err := os.Stat(file.Path)
+		if err != nil || info.Size() != size {
+			continue
+		}
+		candidates = append(candidates, &hashFile{path: file.Path, info: info})
+	}
+	if len(candidates) < 2 {
+		return nil, nil
+	}
+
+	for _, v := range candidates {
+		v.partial, v.full = s.HashGet(v.path, v.info.ModTime(), size)
+	}
+
+	partials := make(map[string][]*hashFile)
+	for _, v := range candidates {
+		if v.partial == "" {
+			hash, err := r.hash(ctx, v.path, size, true)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				logs.Warning("partial hash %s failed, %s", v.path, err.Error())
+				continue
+			}
+			v.partial = hash
+			// small files are hashed whole by the partial hash
+			if size <= 2*DUPLICATE_BLOCK_SIZE {
+				v.full = hash
+			}
+			s.HashSet(v.path, v.info.ModTime(), size, v.partial, v.full)
+		}
+		partials[v.partial] = append(partials[v.partial], v)
+	}
+
+	fulls := make(map[string][]*hashFile)
+	for _, group := range partials {
+		if len(group) < 2 {
+			continue
+		}
+		for _, v := range group {
+			if v.full == "" {
+				hash, err := r.hash(ctx, v.path, size, false)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					logs.Warning("full hash %s failed, %s", v.path, err.Error())
+					continue
+				}
+				v.full = hash
+				s.HashSet(v.path, v.info.ModTime(), size, v.partial, v.full)
+			}
+			fulls[v.full] = append(fulls[v.full], v)
+		}
+	}
+
+	output := make([]DuplicateGroup, 0)
+	for hash, group := range fulls {
+		if len(group) < 2 {
+			continue
+		}
+		paths := make([]string, 0, len(group))
+		for _, v := range group {
+			paths = append(paths, v.path)
+		}
+		sort.Strings(paths)
+		output = append(output, DuplicateGroup{
+			Size:   size,
+			Hash:   hash,
+			Wasted: size * int64(len(group)-1),
+			Files:  paths,
+		})
+	}
+	return output, nil
+}
+
+// hash returns the SHA-256 of the file, or of its first and last blocks
+// when partial is set.
+func (r *DuplicateReport) hash(ctx context.Context, path string, size int64, partial bool) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+
+	if partial && size > 2*DUPLICATE_BLOCK_SIZE {
+		_, err = io.CopyN(h, file, DUPLICATE_BLOCK_SIZE)
+		if err != nil {
+			return "", err
+		}
+		_, err = file.Seek(-DUPLICATE_BLOCK_SIZE, io.SeekEnd)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.CopyN(h, file, DUPLICATE_BLOCK_SIZE)
+		if err != nil {
+			return "", err
+		}
+		r.Hashed += 2 * DUPLICATE_BLOCK_SIZE
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	buf := make([]byte, DUPLICATE_READ_SIZE)
+	for {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		n, err := file.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			r.Hashed += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read %s failed, %s", path, err.Error())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var duplicateSeq atomic.Int64
+
+// Duplicates runs FindDuplicates as a job and waits for its report. Each
+// call runs its own job, the report is kept by the closure of the caller.
+func (s *Server) Duplicates(ctx context.Context, minSize int64, sinks []Progress, scopes ...string) (*DuplicateReport, error) {
+	var report *DuplicateReport
+
+	target := fmt.Sprintf("%v min %d #%d", scopes, minSize, duplicateSeq.Add(1))
+	job, err := s.jobs.Start(ctx, "duplicates", target, func(ctx context.Context, progress Progress) (string, error) {
+		r, err := FindDuplicates(ctx, s.sql, minSize, progress, scopes...)
+		if err != nil {
+			return "", err
+		}
+		report = r
+		return fmt.Sprintf("%d groups, %s wasted", len(r.Groups), ByteView(r.Wasted)), nil
+	}, sinks...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if report == nil {
+		result, err := s.jobs.Get(job.ID)
+		if err != nil {
+			return nil, err
+		}
+		if result.Error != "" {
+			return nil, fmt.Errorf("duplicates job %s failed, %s", job.ID, result.Error)
+		}
+		return nil, fmt.Errorf("duplicates job %s %s", job.ID, result.State)
+	}
+	return report, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var EXPORT_LIMIT = 1000000
+var EXPORT_HISTORY_LENGTH = 20
+
+// Export writes the files matching the filter to a CSV file of the export
+// directory in a job. The file is named after the start time, the name is
+// the target of the job and is only visible once the file is complete.
+func (s *Server) Export(filter QueryFilter, details bool) (*Job, error) {
+	if filter.Limit <= 0 || filter.Limit > EXPORT_LIMIT {
+		filter.Limit = EXPORT_LIMIT
+	}
+
+	name := fmt.Sprintf("export-%s.csv", time.Now().Format("20060102-150405.000000"))
+
+	return s.jobs.Start(s.ctx, "export", name, func(ctx context.Context, progress Progress) (string, error) {
+		files, err := s.Find(ctx, progress, filter)
+		if err != nil {
+			return "", err
+		}
+		if ctx.Err() != nil {
+			return "", nil
+		}
+
+		path := filepath.Join(ExportDirGet(), name)
+		err = exportWrite(path, files, details)
+		if err != nil {
+			return "", err
+		}
+		exportTrim()
+
+		return fmt.Sprintf("%d files to %s", len(files), path), nil
+	})
+}
+
+func exportWrite(path string, files []FileInfo, details bool) error {
+	temp := path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return fmt.Errorf("create export %s failed, %s", temp, err.Error())
+	}
+
+	err = writeCSV(file, files, details)
+	file.Close()
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	err = os.Rename(temp, path)
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("rename export %s failed, %s", path, err.Error())
+	}
+	return nil
+}
+
+// exportTrim keeps the newest EXPORT_HISTORY_LENGTH exports, the names sort
+// by their start time.
+func exportTrim() {
+	names, err := filepath.Glob(filepath.Join(ExportDirGet(), "export-*.csv"))
+	if err != nil || len(names) <= EXPORT_HISTORY_LENGTH {
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-EXPORT_HISTORY_LENGTH] {
+		err = os.Remove(name)
+		if err != nil {
+			logs.Warning("remove export %s failed, %s", name, err.Error())
+		}
+	}
+}
+
+// ExportPath returns the path of a finished export by its name.
+func ExportPath(name string) (string, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, "export-") || filepath.Ext(name) != ".csv" {
+		return "", fmt.Errorf("export %s is not valid", name)
+	}
+	path := filepath.Join(ExportDirGet(), name)
+	_, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("export %s not found", name)
+	}
+	return path, nil
+}
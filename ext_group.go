@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type ExtGroup struct {
+	Name string   `json:"name"` // group name
+	Exts []string `json:"exts"` // extension list, such as .pdf
+}
+
+func DefaultExtGroups() []ExtGroup {
+	return []ExtGroup{
+		{"documents", []string{".doc", ".docx", ".pdf", ".odt", ".rtf", ".txt", ".md", ".wps", ".epub"}},
+		{"spreadsheets", []string{".xls", ".xlsx", ".ods", ".csv"}},
+		{"presentations", []string{".ppt", ".pptx", ".odp", ".key"}},
+		{"images", []string{".png", ".jpg", ".jpeg", ".gif", ".bmp", ".webp", ".svg", ".tif", ".tiff", ".ico", ".heic"}},
+		{"audio", []string{".mp3", ".wav", ".flac", ".aac", ".ogg", ".m4a", ".wma"}},
+		{"video", []string{".mp4", ".mkv", ".avi", ".mov", ".wmv", ".flv", ".webm", ".m4v"}},
+		{"archives", []string{".zip", ".rar", ".7z", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".iso"}},
+		{"code", []string{".go", ".c", ".h", ".cpp", ".cs", ".java", ".py", ".js", ".ts", ".rs", ".php", ".sh", ".ps1", ".sql", ".html", ".css", ".json", ".xml", ".yaml"}},
+		{"executables", []string{".exe", ".dll", ".msi", ".bat", ".cmd", ".com"}},
+	}
+}
+
+func extNormalize(ext string) string {
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		return "." + ext
+	}
+	return ext
+}
+
+// ExtGroupGet returns the extensions of the named group, the name is not
+// case sensitive.
+func (c *Config) ExtGroupGet(name string) ([]string, bool) {
+	for _, v := range c.ExtGroups {
+		if strings.EqualFold(v.Name, name) {
+			return v.Exts, true
+		}
+	}
+	return nil, false
+}
+
+func (c *Config) ExtGroupNames() []string {
+	output := make([]string, 0)
+	for _, v := range c.ExtGroups {
+		output = append(output, v.Name)
+	}
+	return output
+}
+
+// Find searches the index like SQLiteDB.Find, with the extension groups of
+// the filter and of its group:<name> terms resolved by the config in use.
+func (s *Server) Find(ctx context.Context, progress Progress, filter QueryFilter) ([]FileInfo, error) {
+	filter = filter.parseTerms()
+
+	config := s.Config()
+	for _, name := range filter.Groups {
+		exts, ok := config.ExtGroupGet(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown extension group %s, groups are %s",
+				name, strings.Join(config.ExtGroupNames(), ", "))
+		}
+		if len(exts) == 0 {
+			return nil, fmt.Errorf("extension group %s is empty", name)
+		}
+		filter.Exts = append(filter.Exts, exts...)
+	}
+	filter.Groups = nil
+
+	return s.sql.Find(ctx, progress, filter)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var API_FEED_PATH = "/api/feed"
+var API_FEED_EVENTS_PATH = "/api/feed/events"
+var FEED_PAGE_LIMIT = 1000
+var FEED_POLL_TIMEOUT = 30 * time.Second
+var FEED_POLL_TIMEOUT_MAX = 5 * time.Minute
+var FEED_HEARTBEAT = 30 * time.Second
+
+// FeedPage is the answer to a feed read, consumers pass Next as the cursor
+// of the following read. Resync is set when changes after the cursor were
+// pruned from the journal or a part of the index was rescanned in bulk, the
+// consumer then has to reload the index and continue from Next.
+type FeedPage struct {
+	Changes []Change `json:"changes"`
+	Next    int64    `json:"next"`
+	Resync  bool     `json:"resync"`
+}
+
+// ChangeFeed serves the change journal as a sequenced feed, waking up the
+// waiting readers when a change was applied to the index.
+type ChangeFeed struct {
+	sync.Mutex
+
+	sql      *SQLiteDB
+	wake     chan struct{}
+	done     chan struct{}
+	unlisten func()
+}
+
+func NewChangeFeed(sql *SQLiteDB) *ChangeFeed {
+	f := &ChangeFeed{sql: sql, wake: make(chan struct{}), done: make(chan struct{})}
+	f.unlisten = sql.AddListener(func(*FileNotify) { f.notify() })
+	return f
+}
+
+func (f *ChangeFeed) notify() {
+	f.Lock()
+	defer f.Unlock()
+
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
+
+func (f *ChangeFeed) waitChan() <-chan struct{} {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.wake
+}
+
+func (f *ChangeFeed) Close() {
+	f.unlisten()
+	close(f.done)
+}
+
+func (f *ChangeFeed) Read(after int64, limit int) (FeedPage, error) {
+	oldest, last, rescan, err := f.sql.JournalBounds()
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	if after > last || (after < last && (oldest == 0 || after < oldest-1 || after < rescan)) {
+		return FeedPage{Changes: make([]Change, 0), Next: last, Resync: true}, nil
+	}
+
+	changes, err := f.sql.ChangesAfter(after, limit)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	page := FeedPage{Changes: changes, Next: after}
+	if len(changes) > 0 {
+		page.Next = changes[len(changes)-1].Seq
+	}
+	return page, nil
+}
+
+// Poll reads the changes after the cursor, waiting up to timeout for the
+// next change when there is none yet.
+func (f *ChangeFeed) Poll(ctx context.Context, after int64, limit int, timeout time.Duration) (FeedPage, error) {
+	wake := f.waitChan()
+
+	page, err := f.Read(after, limit)
+	if err != nil || len(page.Changes) > 0 || page.Resync || timeout <= 0 {
+		return page, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-wake:
+	case <-timer.C:
+		return page, nil
+	case <-ctx.Done():
+		return page, nil
+	case <-f.done:
+		return page, nil
+	}
+	return f.Read(after, limit)
+}
+
+func feedCursor(r *http.Request) (int64, error) {
+	value := r.URL.Query().Get("after")
+	if value == "" {
+		value = r.Header.Get("Last-Event-ID")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	after, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || after < 0 {
+		return 0, fmt.Errorf("invalid cursor %s", value)
+	}
+	return after, nil
+}
+
+// servePoll answers with the changes after the cursor, holding the request
+// for up to the wait seconds until a change arrives.
+func (f *ChangeFeed) servePoll(w http.ResponseWriter, r *http.Request) {
+	after, err := feedCursor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := FEED_PAGE_LIMIT
+	if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 && value < FEED_PAGE_LIMIT {
+		limit = value
+	}
+
+	timeout := FEED_POLL_TIMEOUT
+	if value, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil && value >= 0 {
+		timeout = min(time.Duration(value)*time.Second, FEED_POLL_TIMEOUT_MAX)
+	}
+
+	page, err := f.Poll(r.Context(), after, limit, timeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if page.Resync {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+	serveJSON(w, page)
+}
+
+// serveEvents streams the changes after the cursor as server-sent events,
+// the event id is the sequence number so that reconnecting clients resume
+// with Last-Event-ID.
+func (f *ChangeFeed) serveEvents(w http.ResponseWriter, r *http.Request) {
+	after, err := feedCursor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	logs.Info("change feed stream %s startup, after %d", r.RemoteAddr, after)
+	defer logs.Info("change feed stream %s done", r.RemoteAddr)
+
+	for {
+		page, err := f.Poll(r.Context(), after, FEED_PAGE_LIMIT, FEED_HEARTBEAT)
+		if err != nil {
+			logs.Warning("change feed read failed, %s", err.Error())
+			return
+		}
+
+		if page.Resync {
+			body, _ := json.Marshal(page)
+			fmt.Fprintf(w, "event: resync\ndata: %s\n\n", body)
+			flusher.Flush()
+			return
+		}
+
+		if len(page.Changes) == 0 {
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		for _, change := range page.Changes {
+			body, _ := json.Marshal(change)
+			fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", change.Seq, body)
+		}
+		flusher.Flush()
+		after = page.Next
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-f.done:
+			return
+		default:
+		}
+	}
+}
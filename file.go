@@ -27,6 +27,15 @@ func ConfigDirGet() string {
 	return dir
 }
 
+func ExportDirGet() string {
+	dir := fmt.Sprintf("%s\\export", DEFAULT_HOME)
+	_, err := os.Stat(dir)
+	if err != nil {
+		os.MkdirAll(dir, 0644)
+	}
+	return dir
+}
+
 func appDataDir() string {
 	datadir := os.Getenv("APPDATA")
 	if datadir == "" {
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// FileMeta is the metadata indexed besides name, size and mod time, zero
+// values are unknown on the platform and stored as NULL.
+type FileMeta struct {
+	CreateTime time.Time // 创建时间
+	ChangeTime time.Time // 元数据变更时间
+	AccessTime time.Time // 访问时间
+	Mode       uint32    // 权限位与类型 (os.FileMode)
+	UID        string    // 所有者 ID, windows 为 SID
+	GID        string    // 所属组 ID, windows 为 SID
+	Owner      string    // 所有者名称
+	Group      string    // 所属组名称
+	Inode      uint64    // 文件号, windows 为 file index
+	Device     uint64    // 设备号, windows 为卷序列号
+	Links      uint32    // 硬链接数
+	LinkTarget string    // 符号链接目标
+}
+
+// accountCache resolves owner and group ids to names once per id.
+type accountCache struct {
+	sync.Mutex
+	names map[string]string
+}
+
+var metaAccounts = &accountCache{names: make(map[string]string)}
+
+func (c *accountCache) lookup(id string, resolve func(string) string) string {
+	if id == "" {
+		return ""
+	}
+
+	c.Lock()
+	name, ok := c.names[id]
+	c.Unlock()
+	if ok {
+		return name
+	}
+
+	name = resolve(id)
+
+	c.Lock()
+	c.names[id] = name
+	c.Unlock()
+	return name
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+func nullString(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+func nullUint(v uint64) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return int64(v)
+}
+
+// args returns the values of the metadata columns, btime to link_target.
+func (m FileMeta) args() []interface{} {
+	return []interface{}{
+		nullTime(m.CreateTime), nullTime(m.ChangeTime), nullTime(m.AccessTime),
+		nullUint(uint64(m.Mode)), nullString(m.UID), nullString(m.GID),
+		nullString(m.Owner), nullString(m.Group),
+		nullUint(m.Inode), nullUint(m.Device), nullUint(uint64(m.Links)), nullString(m.LinkTarget),
+	}
+}
+
+// fileMetaRow scans the metadata columns of a row.
+type fileMetaRow struct {
+	createTime, changeTime, accessTime sql.NullInt64
+	mode                               sql.NullInt64
+	uid, gid, owner, group             sql.NullString
+	inode, device, links               sql.NullInt64
+	linkTarget                         sql.NullString
+}
+
+func (r *fileMetaRow) dest() []interface{} {
+	return []interface{}{
+		&r.createTime, &r.changeTime, &r.accessTime, &r.mode, &r.uid, &r.gid,
+		&r.owner, &r.group, &r.inode, &r.device, &r.links, &r.linkTarget,
+	}
+}
+
+func nullTimeGet(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+	return time.Unix(0, v.Int64)
+}
+
+func (r *fileMetaRow) meta() FileMeta {
+	return FileMeta{
+		CreateTime: nullTimeGet(r.createTime),
+		ChangeTime: nullTimeGet(r.changeTime),
+		AccessTime: nullTimeGet(r.accessTime),
+		Mode:       uint32(r.mode.Int64),
+		UID:        r.uid.String,
+		GID:        r.gid.String,
+		Owner:      r.owner.String,
+		Group:      r.group.String,
+		Inode:      uint64(r.inode.Int64),
+		Device:     uint64(r.device.Int64),
+		Links:      uint32(r.links.Int64),
+		LinkTarget: r.linkTarget.String,
+	}
+}
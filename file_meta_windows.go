@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileBasicInfo is FILE_BASIC_INFO of GetFileInformationByHandleEx.
+type fileBasicInfo struct {
+	CreationTime   int64
+	LastAccessTime int64
+	LastWriteTime  int64
+	ChangeTime     int64
+	FileAttributes uint32
+	_              uint32
+}
+
+func filetimeGet(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	ft := windows.Filetime{LowDateTime: uint32(v), HighDateTime: uint32(v >> 32)}
+	return time.Unix(0, ft.Nanoseconds())
+}
+
+func sidAccount(sid string) string {
+	s, err := windows.StringToSid(sid)
+	if err != nil {
+		return ""
+	}
+	account, domain, _, err := s.LookupAccount("")
+	if err != nil {
+		return ""
+	}
+	if domain == "" {
+		return account
+	}
+	return domain + "\\" + account
+}
+
+// FileMetaGet reads the metadata of path through one handle opened without
+// following reparse points, what can not be read is left zero.
+func FileMetaGet(path string, info os.FileInfo) FileMeta {
+	meta := FileMeta{Mode: uint32(info.Mode())}
+
+	handle, err := windows.CreateFile(
+		windows.StringToUTF16Ptr(path),
+		windows.FILE_READ_ATTRIBUTES|windows.READ_CONTROL,
+		FILE_SHARE_READ|FILE_SHARE_WRITE|FILE_SHARE_DELETE,
+		nil,
+		OPEN_EXISTING,
+		FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OPEN_REPARSE_POINT,
+		0,
+	)
+	if err != nil {
+		return meta
+	}
+	defer windows.CloseHandle(handle)
+
+	var basic fileBasicInfo
+	err = windows.GetFileInformationByHandleEx(handle, windows.FileBasicInfo,
+		(*byte)(unsafe.Pointer(&basic)), uint32(unsafe.Sizeof(basic)))
+	if err == nil {
+		meta.CreateTime = filetimeGet(basic.CreationTime)
+		meta.ChangeTime = filetimeGet(basic.ChangeTime)
+		meta.AccessTime = filetimeGet(basic.LastAccessTime)
+	}
+
+	var data windows.ByHandleFileInformation
+	err = windows.GetFileInformationByHandle(handle, &data)
+	if err == nil {
+		meta.Inode = uint64(data.FileIndexHigh)<<32 | uint64(data.FileIndexLow)
+		meta.Device = uint64(data.VolumeSerialNumber)
+		meta.Links = data.NumberOfLinks
+		if data.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0 {
+			meta.LinkTarget, _ = os.Readlink(path)
+		}
+	}
+
+	sd, err := windows.GetSecurityInfo(handle, windows.SE_FILE_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION)
+	if err == nil {
+		if owner, _, err := sd.Owner(); err == nil && owner != nil {
+			meta.UID = owner.String()
+			meta.Owner = metaAccounts.lookup(meta.UID, sidAccount)
+		}
+		if group, _, err := sd.Group(); err == nil && group != nil {
+			meta.GID = group.String()
+			meta.Group = metaAccounts.lookup(meta.GID, sidAccount)
+		}
+	}
+
+	return meta
+}
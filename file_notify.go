@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/astaxie/beego/logs"
@@ -33,15 +35,50 @@ const (
 	FILE_RENAME_OLD
 	FILE_RENAME_NEW
 	FILE_EVENT_MAX
+	FILE_RESCAN // not a watcher action, a subtree was rewritten in bulk
 )
 
+var WATCHER_RESCAN_DELAY = 5 * time.Second
+var WATCHER_ERROR_BACKOFF = time.Second
+var WATCHER_CANCEL_INTERVAL = 100 * time.Millisecond
+
+type WatcherStatus struct {
+	Root         string    `json:"root"`
+	Running      bool      `json:"running"`
+	Health       string    `json:"health"` // ok, overflow, error, stopped
+	Events       uint64    `json:"events"`
+	Errors       uint64    `json:"errors"`
+	LastError    string    `json:"last_error,omitempty"`
+	Overflows    uint64    `json:"overflows"`
+	LastOverflow time.Time `json:"last_overflow,omitempty"`
+	Rescans      uint64    `json:"rescans"`
+}
+
+type driveWatcher struct {
+	sync.Mutex
+
+	handle windows.Handle // owned and closed by the task
+	closed bool
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
 type FileEvent struct {
 	sync.WaitGroup
 
-	shutdown bool
-	config   Config
+	ctx      context.Context
+	cancel   context.CancelFunc
 	sql      *SQLiteDB
-	handles  map[string]windows.Handle
+	coalesce *Coalescer
+	overflow func(roots ...string)
+
+	configLock sync.RWMutex
+	config     Config
+
+	statusLock sync.Mutex
+	watchers   map[string]*driveWatcher
+	status     map[string]*WatcherStatus
+	rescan     map[string]*time.Timer
 }
 
 func WindowCreateFile(driveName string) (windows.Handle, error) {
@@ -78,69 +115,285 @@ func ReadDirectoryChanges(handle windows.Handle, buffer []byte) (uint32, error)
 		nil,
 		0,
 	)
-	if err != nil {
+	if err != nil && err != windows.ERROR_OPERATION_ABORTED {
 		logs.Warning("call windows.ReadDirectoryChanges failed, %s", err.Error())
 	}
 	return bytesReturned, err
 }
 
-func NewFileEvent(s *SQLiteDB, config Config) (*FileEvent, error) {
-	e := &FileEvent{handles: make(map[string]windows.Handle, 0), sql: s, config: config}
+// NewFileEvent watches the enabled drives until ctx is done or Close,
+// overflow is called with the root or directory to rescan when events were
+// lost.
+func NewFileEvent(ctx context.Context, s *SQLiteDB, config Config, overflow func(roots ...string)) (*FileEvent, error) {
+	e := &FileEvent{sql: s, config: config, overflow: overflow, watchers: make(map[string]*driveWatcher),
+		status: make(map[string]*WatcherStatus), rescan: make(map[string]*time.Timer)}
+
+	e.ctx, e.cancel = context.WithCancel(ctx)
+	e.coalesce = NewCoalescer(s, e.Config, overflow)
 
 	for _, v := range config.SearchDrives {
 		if !v.Enable {
 			continue
 		}
-		handle, err := WindowCreateFile(v.Name)
+		err := e.Watch(v.Name)
 		if err != nil {
+			e.Close()
 			return nil, err
 		}
-		e.handles[v.Name] = handle
 	}
 
-	for name, handle := range e.handles {
-		e.Add(1)
-		go e.listenDriveTask(name, handle, config.CacheLength)
+	return e, nil
+}
+
+func (e *FileEvent) Config() Config {
+	e.configLock.RLock()
+	defer e.configLock.RUnlock()
+	return e.config
+}
+
+// SetConfig changes the filters applied to the events, the watchers started
+// from now on use its cache length.
+func (e *FileEvent) SetConfig(config Config) {
+	e.configLock.Lock()
+	e.config = config
+	e.configLock.Unlock()
+}
+
+// Watch starts watching the drive name, nothing when already watched.
+func (e *FileEvent) Watch(name string) error {
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+
+	if e.ctx.Err() != nil {
+		return fmt.Errorf("file event closed")
+	}
+	if _, ok := e.watchers[name]; ok {
+		return nil
+	}
+
+	handle, err := WindowCreateFile(name)
+	if err != nil {
+		return err
 	}
 
-	return e, nil
+	ctx, cancel := context.WithCancel(e.ctx)
+	w := &driveWatcher{handle: handle, cancel: cancel, done: make(chan struct{})}
+	e.watchers[name] = w
+	e.status[name] = &WatcherStatus{Root: name, Running: true, Health: "ok"}
+
+	e.Add(1)
+	go e.listenDriveTask(ctx, name, w, e.Config().CacheLength)
+
+	return nil
+}
+
+// Unwatch stops watching the drive name and waits for its task at most
+// SHUTDOWN_TIMEOUT, the task closes the handle when it stops.
+func (e *FileEvent) Unwatch(name string) {
+	e.statusLock.Lock()
+	w, ok := e.watchers[name]
+	delete(e.watchers, name)
+	delete(e.status, name)
+	if timer, ok := e.rescan[name]; ok {
+		timer.Stop()
+		delete(e.rescan, name)
+	}
+	e.statusLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	w.stop(name)
+	select {
+	case <-w.done:
+	case <-time.After(SHUTDOWN_TIMEOUT):
+		logs.Warning("file change task of %s not stopped within %s", name, SHUTDOWN_TIMEOUT)
+	}
+
+	logs.Info("unwatch drive %s", name)
 }
 
+// Watching returns the watched drives.
+func (e *FileEvent) Watching() []string {
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+
+	output := make([]string, 0, len(e.watchers))
+	for name := range e.watchers {
+		output = append(output, name)
+	}
+	return output
+}
+
+// stop cancels the context of the task and keeps cancelling its reads until
+// it stopped, a read started after the task checked the context is cancelled
+// by the next try.
+func (w *driveWatcher) stop(name string) {
+	w.cancel()
+
+	go func() {
+		ticker := time.NewTicker(WATCHER_CANCEL_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			w.cancelRead(name)
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (w *driveWatcher) cancelRead(name string) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return
+	}
+	err := windows.CancelIoEx(w.handle, nil)
+	if err != nil && err != windows.ERROR_NOT_FOUND {
+		logs.Warning("cancel file change read of %s failed, %s", name, err.Error())
+	}
+}
+
+// closeHandle closes the handle once, the cancels never see a handle value
+// reused after it was closed.
+func (w *driveWatcher) closeHandle() {
+	w.Lock()
+	defer w.Unlock()
+
+	if !w.closed {
+		windows.CloseHandle(w.handle)
+		w.closed = true
+	}
+}
+
+// Close cancels the pending reads of the watchers and waits for them at
+// most SHUTDOWN_TIMEOUT, and flushes the coalescer. Each task closes its
+// handle when it stops.
 func (e *FileEvent) Close() {
-	e.shutdown = true
-	// e.Wait()
-	logs.Info("file event ready close")
+	e.cancel()
+
+	e.statusLock.Lock()
+	for _, timer := range e.rescan {
+		timer.Stop()
+	}
+	watchers := e.watchers
+	e.watchers = make(map[string]*driveWatcher)
+	e.statusLock.Unlock()
+
+	for name, w := range watchers {
+		w.stop(name)
+	}
+	if !WaitTimeout(&e.WaitGroup, SHUTDOWN_TIMEOUT) {
+		logs.Warning("file change tasks not stopped within %s", SHUTDOWN_TIMEOUT)
+	}
+
+	e.coalesce.Close()
+
+	logs.Info("file event closed")
+}
+
+func (e *FileEvent) CoalesceStatus() CoalesceStatus {
+	return e.coalesce.Status()
+}
+
+func (e *FileEvent) Status() []WatcherStatus {
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+
+	output := make([]WatcherStatus, 0)
+	for _, v := range e.status {
+		output = append(output, *v)
+	}
+	return output
+}
+
+func (e *FileEvent) statusUpdate(name string, fn func(*WatcherStatus)) {
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+
+	if status, ok := e.status[name]; ok {
+		fn(status)
+	}
+}
+
+// overflowed counts a lost batch of events of the root and schedules a
+// reconcile rescan of it once the burst settled for WATCHER_RESCAN_DELAY.
+func (e *FileEvent) overflowed(name string) {
+	logs.Warning("file change buffer of %s overflow, events lost, cache length %d", name, e.Config().CacheLength)
+
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+
+	if status, ok := e.status[name]; ok {
+		status.Overflows++
+		status.LastOverflow = time.Now()
+		status.Health = "overflow"
+	}
+
+	if e.overflow == nil || e.ctx.Err() != nil {
+		return
+	}
+	if timer, ok := e.rescan[name]; ok {
+		timer.Reset(WATCHER_RESCAN_DELAY)
+		return
+	}
+	e.rescan[name] = time.AfterFunc(WATCHER_RESCAN_DELAY, func() {
+		e.statusLock.Lock()
+		delete(e.rescan, name)
+		if status, ok := e.status[name]; ok {
+			status.Rescans++
+		}
+		e.statusLock.Unlock()
+
+		if e.ctx.Err() == nil {
+			logs.Info("rescan %s after file change overflow", name)
+			e.overflow(name)
+		}
+	})
 }
 
+// parseEvents hands the events of a buffer to the coalescer, events of
+// excluded paths are dropped here and rename pairs are kept together.
 func (e *FileEvent) parseEvents(driveName string, data []byte) {
 	var offset uint32 = 0
+	renameOld := ""
+	config := e.Config()
+
 	for {
 		event := (*FILE_NOTIFY_INFORMATION)(unsafe.Pointer(&data[offset]))
 
 		filePath := driveName + syscall.UTF16ToString((*[1 << 20]uint16)(unsafe.Pointer(&event.FileName))[:event.FileNameLength/2])
 
-		if event.Action < FILE_EVENT_MAX && !e.config.CheckFolder(filePath) {
+		if event.Action >= FILE_EVENT_MAX || config.CheckFolder(filePath) {
+			e.coalesce.Drop()
+			if event.Action == FILE_RENAME_NEW && renameOld != "" {
+				// moved into an excluded folder
+				e.coalesce.Event(FILE_REMOVE, renameOld)
+				renameOld = ""
+			}
+		} else {
+			if renameOld != "" && event.Action != FILE_RENAME_NEW {
+				e.coalesce.Event(FILE_REMOVE, renameOld)
+				renameOld = ""
+			}
+
 			switch event.Action {
-			case FILE_ADD:
-				fallthrough
-			case FILE_MODIFIED:
-				fallthrough
-			case FILE_RENAME_NEW:
-				{
-					fileInfo, err := NewFileInfo(filePath)
-					if err != nil {
-						logs.Warning("load %s file info failed, %s", filePath, err.Error())
-					} else {
-						e.sql.Notify() <- &FileNotify{Event: event.Action, File: *fileInfo}
-					}
-				}
-			case FILE_REMOVE:
-				fallthrough
+			case FILE_ADD, FILE_MODIFIED, FILE_REMOVE:
+				e.coalesce.Event(event.Action, filePath)
 			case FILE_RENAME_OLD:
-				{
-					e.sql.Notify() <- &FileNotify{Event: event.Action, File: FileInfo{
-						Path: filePath,
-					}}
+				renameOld = filePath
+			case FILE_RENAME_NEW:
+				if renameOld != "" {
+					e.coalesce.Rename(renameOld, filePath)
+					renameOld = ""
+				} else {
+					e.coalesce.Event(FILE_ADD, filePath)
 				}
 			}
 		}
@@ -150,26 +403,55 @@ func (e *FileEvent) parseEvents(driveName string, data []byte) {
 		}
 		offset += event.NextEntryOffset
 	}
+
+	if renameOld != "" {
+		e.coalesce.Event(FILE_REMOVE, renameOld)
+	}
 }
 
-func (e *FileEvent) listenDriveTask(name string, handle windows.Handle, cacheLength uint32) {
+// listenDriveTask reads the changes of a drive until ctx is done, Close and
+// Unwatch cancel the blocking read. The task owns the handle and closes it
+// on exit.
+func (e *FileEvent) listenDriveTask(ctx context.Context, name string, w *driveWatcher, cacheLength uint32) {
 	defer e.Done()
-	defer windows.CloseHandle(handle)
+	defer close(w.done)
+	defer w.closeHandle()
 
 	logs.Info("listen drive file change task startup")
 
 	buffer := make([]byte, cacheLength)
 
-	for {
-		if e.shutdown {
+	for ctx.Err() == nil {
+		bytesReturned, err := ReadDirectoryChanges(w.handle, buffer)
+		if ctx.Err() != nil {
 			break
 		}
-
-		bytesReturned, err := ReadDirectoryChanges(handle, buffer)
-		if err == nil {
+		if err == windows.ERROR_NOTIFY_ENUM_DIR || (err == nil && bytesReturned == 0) {
+			// the buffer overflowed, the changes since the last read are lost
+			e.overflowed(name)
+		} else if err == nil {
+			e.statusUpdate(name, func(status *WatcherStatus) {
+				status.Events++
+				status.Health = "ok"
+			})
 			e.parseEvents(name, buffer[:bytesReturned])
+		} else {
+			e.statusUpdate(name, func(status *WatcherStatus) {
+				status.Errors++
+				status.LastError = err.Error()
+				status.Health = "error"
+			})
+			select {
+			case <-ctx.Done():
+			case <-time.After(WATCHER_ERROR_BACKOFF):
+			}
 		}
 	}
 
+	e.statusUpdate(name, func(status *WatcherStatus) {
+		status.Running = false
+		status.Health = "stopped"
+	})
+
 	logs.Info("listen drive file change task done")
 }
@@ -1,20 +1,23 @@
 module github.com/linimbus/go-mcp-file-server
 
-go 1.23.3
+go 1.25.5
 
 require (
 	github.com/astaxie/beego v1.12.3
 	github.com/lxn/walk v0.0.0-20210112085537-c389da54e794
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e
-	github.com/mark3labs/mcp-go v0.17.0
+	github.com/mark3labs/mcp-go v1.1.1
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	golang.org/x/sys v0.31.0
-	golang.org/x/text v0.3.0
+	golang.org/x/text v0.14.0
 )
 
 require (
+	github.com/google/jsonschema-go v0.4.2 // indirect
 	github.com/google/uuid v1.6.0 // indirect
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2 // indirect
 	github.com/shiena/ansicolor v0.0.0-20151119151921-a422bbe96644 // indirect
+	github.com/spf13/cast v1.7.1 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
 	gopkg.in/Knetic/govaluate.v3 v3.0.0 // indirect
 )
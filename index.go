@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var INDEX_STATUS_JOBS = 10
+var RESCAN_MAX_ROOTS = 32
+
+type RootStatus struct {
+	Root     string `json:"root"`
+	Files    int    `json:"files"`
+	LastScan string `json:"last_scan,omitempty"`
+}
+
+type IndexStatus struct {
+	Files         int             `json:"files"`
+	ReadOnly      bool            `json:"read_only"`
+	Roots         []RootStatus    `json:"roots"`
+	Watchers      []WatcherStatus `json:"watchers"`
+	QueueDepth    int             `json:"queue_depth"`
+	QueueCapacity int             `json:"queue_capacity"`
+	Coalesce      CoalesceStatus  `json:"coalesce"`
+	Jobs          []Job           `json:"jobs"`
+}
+
+func (s *Server) IndexStatus() IndexStatus {
+	status := IndexStatus{
+		ReadOnly:      s.sql.ReadOnly(),
+		Roots:         make([]RootStatus, 0),
+		Watchers:      make([]WatcherStatus, 0),
+		QueueDepth:    len(s.sql.Notify()),
+		QueueCapacity: cap(s.sql.Notify()),
+		Jobs:          make([]Job, 0),
+	}
+
+	jobs, err := s.jobs.List("", "", INDEX_STATUS_JOBS)
+	if err == nil {
+		status.Jobs = jobs
+	}
+
+	cnt, err := s.sql.Count()
+	if err == nil {
+		status.Files = cnt
+	}
+
+	counts, _ := s.sql.CountByDrive()
+	for _, v := range s.Config().SearchDrives {
+		if !v.Enable {
+			continue
+		}
+		status.Roots = append(status.Roots, RootStatus{
+			Root:     v.Name,
+			Files:    counts[v.Name],
+			LastScan: s.sql.MetaGet("last_scan:" + v.Name),
+		})
+	}
+
+	if s.file != nil {
+		status.Watchers = s.file.Status()
+		status.Coalesce = s.file.CoalesceStatus()
+		sort.Slice(status.Watchers, func(i, j int) bool {
+			return status.Watchers[i].Root < status.Watchers[j].Root
+		})
+	}
+
+	return status
+}
+
+// IndexRescan starts an asynchronous rescan of path, or of all enabled
+// drives when path is empty, and reports its progress to the sinks. A
+// running rescan of the same path is shared by its callers, so it runs with
+// the server context and is only stopped by a shutdown or job_cancel.
+func (s *Server) IndexRescan(path string, sinks ...Progress) (*Job, error) {
+	if s.sql.ReadOnly() {
+		return nil, fmt.Errorf("index is read-only")
+	}
+
+	config := s.Config()
+
+	roots := make([]string, 0)
+	if path == "" {
+		for _, v := range config.SearchDrives {
+			if v.Enable {
+				roots = append(roots, v.Name)
+			}
+		}
+	} else {
+		path = filepath.Clean(path)
+		if !config.CheckAccess(path) {
+			return nil, fmt.Errorf("path %s is not under an indexed drive", path)
+		}
+		roots = append(roots, path)
+	}
+
+	return s.jobs.Start(s.ctx, "rescan", path, func(ctx context.Context, progress Progress) (string, error) {
+		files := 0
+		for _, root := range roots {
+			count, err := SubtreeScan(ctx, s.sql, config, root, ProgressFunc(func(current, total int64, message string) {
+				progress.Update(int64(files)+current, 0, message)
+			}))
+			files += count
+			if err != nil {
+				return fmt.Sprintf("%d files", files), err
+			}
+		}
+		ShowRowCount(s.sql)
+		return fmt.Sprintf("%d files", files), nil
+	}, sinks...)
+}
+
+// lostRescan reconciles the roots or directories whose changes were lost.
+// They are queued by drive and walked one after another by a single
+// reconcile job per drive, collapsed into at most RESCAN_MAX_ROOTS common
+// ancestors.
+func (s *Server) lostRescan(roots ...string) {
+	s.lostLock.Lock()
+	defer s.lostLock.Unlock()
+
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		drive := root
+		if len(root) > 3 {
+			drive = root[:3]
+		}
+		s.lost[drive] = append(s.lost[drive], root)
+	}
+
+	for drive, queued := range s.lost {
+		s.lost[drive] = rescanCollapse(queued, RESCAN_MAX_ROOTS)
+		if s.lostActive[drive] || len(queued) == 0 {
+			continue
+		}
+		start := time.Now()
+		job, err := s.jobs.Start(s.ctx, "reconcile", drive, func(ctx context.Context, progress Progress) (string, error) {
+			return s.reconcileDrive(ctx, drive, progress)
+		})
+		if err != nil {
+			logs.Error("reconcile %s failed, %s", drive, err.Error())
+			continue
+		}
+		if job.StartTime.Before(start) {
+			// the last reconcile of the drive is finishing, start again after it
+			go func() {
+				if job.Wait(s.ctx) == nil {
+					s.lostRescan()
+				}
+			}()
+			continue
+		}
+		s.lostActive[drive] = true
+		logs.Info("reconcile %s, job %s", drive, job.ID)
+	}
+}
+
+// lostTake returns the queued roots of drive, or marks the reconcile of the
+// drive stopped when none are left.
+func (s *Server) lostTake(drive string, stop bool) []string {
+	s.lostLock.Lock()
+	defer s.lostLock.Unlock()
+
+	roots := s.lost[drive]
+	delete(s.lost, drive)
+	if stop || len(roots) == 0 {
+		s.lostActive[drive] = false
+	}
+	if stop {
+		return nil
+	}
+	return roots
+}
+
+func (s *Server) reconcileDrive(ctx context.Context, drive string, progress Progress) (string, error) {
+	files, dirs := 0, 0
+	for ctx.Err() == nil {
+		roots := s.lostTake(drive, false)
+		if len(roots) == 0 {
+			ShowRowCount(s.sql)
+			return fmt.Sprintf("%d directories, %d files", dirs, files), nil
+		}
+
+		config := s.Config()
+		for _, root := range roots {
+			if ctx.Err() != nil {
+				break
+			}
+			if !config.CheckAccess(root) {
+				continue
+			}
+			count, err := SubtreeScan(ctx, s.sql, config, root, ProgressFunc(func(current, total int64, message string) {
+				progress.Update(int64(files)+current, 0, message)
+			}))
+			files += count
+			dirs++
+			if err != nil {
+				s.lostTake(drive, true)
+				return fmt.Sprintf("%d directories, %d files", dirs, files), err
+			}
+		}
+	}
+	s.lostTake(drive, true)
+	return fmt.Sprintf("%d directories, %d files", dirs, files), nil
+}
+
+// rescanCollapse drops the roots below another root and replaces the
+// deepest roots by their parents until at most limit are left.
+func rescanCollapse(roots []string, limit int) []string {
+	roots = PathsOutermost(roots)
+	for len(roots) > limit {
+		depth := 0
+		for _, root := range roots {
+			depth = max(depth, strings.Count(strings.TrimSuffix(root, string(filepath.Separator)), string(filepath.Separator)))
+		}
+		if depth == 0 {
+			break
+		}
+		for i, root := range roots {
+			if strings.Count(strings.TrimSuffix(root, string(filepath.Separator)), string(filepath.Separator)) == depth {
+				roots[i] = filepath.Dir(root)
+			}
+		}
+		roots = PathsOutermost(roots)
+	}
+	return roots
+}
+
+// dirtyRescan reconciles the directories left dirty by the last shutdown.
+func (s *Server) dirtyRescan() {
+	value := s.sql.MetaGet(DIRTY_META_KEY)
+	if value == "" {
+		return
+	}
+	s.sql.MetaSet(DIRTY_META_KEY, "")
+
+	dirs := make([]string, 0)
+	err := json.Unmarshal([]byte(value), &dirs)
+	if err != nil {
+		logs.Warning("parse dirty directories failed, %s", err.Error())
+		return
+	}
+	s.lostRescan(dirs...)
+}
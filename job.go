@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var JOB_HISTORY_LENGTH = 200
+
+type Job struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"` // rescan, rebuild, hash ...
+	Target    string    `json:"target,omitempty"`
+	State     string    `json:"state"` // running, done, failed, cancelled, interrupted
+	Current   int64     `json:"current"`
+	Total     int64     `json:"total,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time,omitempty"`
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Wait blocks until the job is finished or ctx is done.
+func (j *Job) Wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// JobFunc runs the job until done or ctx is cancelled, reporting to progress,
+// and returns a short result summary.
+type JobFunc func(ctx context.Context, progress Progress) (string, error)
+
+// JobManager runs jobs in the background and keeps their history in the
+// job_history table, the running jobs are kept in memory with their cancel.
+// All jobs are cancelled with the context of the manager.
+type JobManager struct {
+	sync.Mutex
+	sync.WaitGroup
+
+	ctx     context.Context
+	sql     *SQLiteDB
+	seq     int
+	running map[string]*Job
+}
+
+func NewJobManager(ctx context.Context, sql *SQLiteDB) *JobManager {
+	if !sql.ReadOnly() {
+		cnt := sql.JobInterrupt(time.Now())
+		if cnt > 0 {
+			logs.Warning("%d jobs interrupted by the last shutdown", cnt)
+		}
+	}
+	return &JobManager{ctx: ctx, sql: sql, running: make(map[string]*Job)}
+}
+
+// Start runs fn as a new job, cancelled with ctx, the manager context or
+// Cancel. A running job of the same kind and target is returned instead of
+// starting another one.
+func (m *JobManager) Start(ctx context.Context, kind string, target string, fn JobFunc, sinks ...Progress) (*Job, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.ctx.Err() != nil {
+		return nil, fmt.Errorf("server is shutting down")
+	}
+
+	for _, v := range m.running {
+		if v.Kind == kind && v.Target == target {
+			return v, nil
+		}
+	}
+
+	m.seq++
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(m.ctx, cancel)
+
+	job := &Job{
+		ID:        fmt.Sprintf("%s-%d-%d", kind, time.Now().UnixNano(), m.seq),
+		Kind:      kind,
+		Target:    target,
+		State:     "running",
+		StartTime: time.Now(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	m.running[job.ID] = job
+	m.sql.JobSave(*job)
+
+	name := fmt.Sprintf("%s job %s", kind, job.ID)
+	if target != "" {
+		name = fmt.Sprintf("%s job %s %s", kind, job.ID, target)
+	}
+	progress := StartProgress(name, append([]Progress{ProgressFunc(func(current, total int64, message string) {
+		m.Lock()
+		job.Current, job.Total, job.Message = current, total, message
+		m.Unlock()
+	})}, sinks...)...)
+
+	m.Add(1)
+	go func() {
+		defer stop()
+		m.runTask(ctx, job, fn, progress)
+	}()
+
+	return job, nil
+}
+
+func (m *JobManager) runTask(ctx context.Context, job *Job, fn JobFunc, progress *Operation) {
+	defer m.Done()
+	defer close(job.done)
+	defer job.cancel()
+
+	result, err := fn(ctx, progress)
+
+	m.Lock()
+	job.Result = result
+	job.EndTime = time.Now()
+	if err != nil {
+		job.State = "failed"
+		job.Error = err.Error()
+	} else if ctx.Err() != nil {
+		job.State = "cancelled"
+	} else {
+		job.State = "done"
+	}
+	delete(m.running, job.ID)
+	m.sql.JobSave(*job)
+	state := job.State
+	m.Unlock()
+
+	m.sql.JobTrim(JOB_HISTORY_LENGTH)
+
+	progress.Done(fmt.Sprintf("%s, %s", state, result))
+}
+
+func (m *JobManager) Cancel(id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	job, ok := m.running[id]
+	if !ok {
+		return fmt.Errorf("job %s is not running", id)
+	}
+	logs.Info("job %s cancel", id)
+	job.cancel()
+	return nil
+}
+
+func (m *JobManager) CancelAll() {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, job := range m.running {
+		job.cancel()
+	}
+}
+
+// Busy reports whether a job of another kind than except is running.
+func (m *JobManager) Busy(except string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, job := range m.running {
+		if job.Kind != except {
+			return true
+		}
+	}
+	return false
+}
+
+// CancelTarget cancels the running jobs with a target under root and
+// returns them.
+func (m *JobManager) CancelTarget(root string) []*Job {
+	m.Lock()
+	defer m.Unlock()
+
+	output := make([]*Job, 0)
+	for _, job := range m.running {
+		if job.Target != "" && PathWithin(job.Target, root) {
+			logs.Info("job %s cancel", job.ID)
+			job.cancel()
+			output = append(output, job)
+		}
+	}
+	return output
+}
+
+func (m *JobManager) Get(id string) (Job, error) {
+	m.Lock()
+	job, ok := m.running[id]
+	var snapshot Job
+	if ok {
+		snapshot = *job
+	}
+	m.Unlock()
+
+	if ok {
+		return snapshot, nil
+	}
+	return m.sql.JobGet(id)
+}
+
+// List returns the newest jobs first, state and kind filter when not empty.
+func (m *JobManager) List(state string, kind string, limit int) ([]Job, error) {
+	jobs, err := m.sql.JobList(state, kind, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	exist := make(map[string]bool)
+	for i := range jobs {
+		if v, ok := m.running[jobs[i].ID]; ok {
+			jobs[i] = *v
+		}
+		exist[jobs[i].ID] = true
+	}
+	for _, v := range m.running {
+		if exist[v.ID] || (state != "" && v.State != state) || (kind != "" && v.Kind != kind) {
+			continue
+		}
+		jobs = append(jobs, *v)
+	}
+	m.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartTime.After(jobs[j].StartTime)
+	})
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/astaxie/beego/logs"
+	"golang.org/x/sys/windows"
+)
+
+var DATABASE_LOCK_FILE = "sqlite3.lock"
+
+// IndexLock marks the process that owns (and writes) the sqlite3 index,
+// other instances open the index read-only.
+type IndexLock struct {
+	file *os.File
+}
+
+func IndexLockAcquire() (*IndexLock, error) {
+	path := filepath.Join(ConfigDirGet(), DATABASE_LOCK_FILE)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file %s failed, %s", path, err.Error())
+	}
+
+	var overlapped windows.Overlapped
+	err = windows.LockFileEx(windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &overlapped)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("index owned by another instance, %s", err.Error())
+	}
+
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d", os.Getpid())
+
+	logs.Info("index lock acquired by pid %d", os.Getpid())
+	return &IndexLock{file: file}, nil
+}
+
+func (l *IndexLock) Release() {
+	var overlapped windows.Overlapped
+	err := windows.UnlockFileEx(windows.Handle(l.file.Fd()), 0, 1, 0, &overlapped)
+	if err != nil {
+		logs.Warning("index unlock failed, %s", err.Error())
+	}
+	l.file.Close()
+	logs.Info("index lock released")
+}
@@ -1,6 +1,22 @@
 package main
 
+import "flag"
+
+var stdioMode = flag.Bool("stdio", false, "serve mcp over stdin/stdout instead of the gui")
+
 func main() {
+	flag.Parse()
+
+	if *stdioMode {
+		StdioMain()
+		return
+	}
+
+	if *duplicatesMode {
+		DuplicatesMain()
+		return
+	}
+
 	FileInit()
 	LogInit()
 	IconInit()
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var COMPLETION_MAX = 100
+var COMPLETION_EXT_SAMPLE = 8
+
+type MCPCompletion struct {
+	mcp *MCPServer
+}
+
+func (c *MCPCompletion) CompletePromptArgument(ctx context.Context, promptName string, argument mcp.CompleteArgument, context mcp.CompleteContext) (*mcp.Completion, error) {
+	logs.Info("mcp complete prompt %s argument %s: %s", promptName, argument.Name, argument.Value)
+	return c.complete(ctx, argument, false), nil
+}
+
+func (c *MCPCompletion) CompleteResourceArgument(ctx context.Context, uri string, argument mcp.CompleteArgument, context mcp.CompleteContext) (*mcp.Completion, error) {
+	logs.Info("mcp complete resource %s argument %s: %s", uri, argument.Name, argument.Value)
+	return c.complete(ctx, argument, true), nil
+}
+
+func (c *MCPCompletion) complete(ctx context.Context, argument mcp.CompleteArgument, slash bool) *mcp.Completion {
+	switch strings.ToLower(argument.Name) {
+	case "path", "folder", "directory":
+		return c.completePath(ctx, argument.Value, slash)
+	case "ext", "extension":
+		return c.completeExt(ctx, argument.Value)
+	case "group":
+		return completionResult(c.completeGroups(argument.Value), false)
+	case "root", "drive":
+		return completionResult(c.completeRoots(argument.Value), false)
+	}
+	return completionResult([]string{}, false)
+}
+
+func completionResult(values []string, hasMore bool) *mcp.Completion {
+	if len(values) > COMPLETION_MAX {
+		values = values[:COMPLETION_MAX]
+		hasMore = true
+	}
+	return &mcp.Completion{Values: values, Total: len(values), HasMore: hasMore}
+}
+
+func (c *MCPCompletion) completeRoots(value string) []string {
+	output := make([]string, 0)
+	for _, v := range c.mcp.Config().SearchDrives {
+		if v.Enable && strings.HasPrefix(strings.ToLower(v.Name), strings.ToLower(value)) {
+			output = append(output, v.Name)
+		}
+	}
+	return output
+}
+
+func (c *MCPCompletion) completeGroups(value string) []string {
+	output := make([]string, 0)
+	config := c.mcp.Config()
+	for _, name := range config.ExtGroupNames() {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(value)) {
+			output = append(output, name)
+		}
+	}
+	return output
+}
+
+// allowed also accepts the parents of the session roots, so that the
+// completion can walk down to them.
+func (c *MCPCompletion) allowed(ctx context.Context, path string) bool {
+	if c.mcp.allowed(ctx, path) {
+		return true
+	}
+	for _, root := range c.mcp.roots.Get(ctx) {
+		if PathWithin(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// completePath completes the next path segment after the prefix, directories
+// are returned with a trailing separator.
+func (c *MCPCompletion) completePath(ctx context.Context, value string, slash bool) *mcp.Completion {
+	prefix := filepath.FromSlash(value)
+
+	roots := c.completeRoots(prefix)
+	if len(roots) > 0 && len(prefix) < len(roots[0]) {
+		return completionResult(roots, false)
+	}
+
+	// skip scan of the distinct next segments, a directory below the prefix
+	// is left by jumping past its subtree, ']' follows the separator
+	exist := make(map[string]bool)
+	output := make([]string, 0)
+	cursor, after := prefix, false
+
+	for seek := 0; seek < COMPLETION_MAX*4 && len(output) <= COMPLETION_MAX; seek++ {
+		path, isDir, err := c.mcp.sql.PathNext(prefix, cursor, after)
+		if err != nil || path == "" {
+			return completionResult(output, false)
+		}
+
+		segment := path
+		cursor, after = path, true
+		if i := strings.IndexRune(path[len(prefix):], filepath.Separator); i >= 0 {
+			segment = path[:len(prefix)+i+1]
+			cursor, after = segment[:len(segment)-1]+"]", false
+		} else if isDir {
+			segment = path + string(filepath.Separator)
+		}
+
+		if exist[strings.ToLower(segment)] || !c.allowed(ctx, strings.TrimSuffix(segment, string(filepath.Separator))) {
+			continue
+		}
+		exist[strings.ToLower(segment)] = true
+		if slash {
+			output = append(output, filepath.ToSlash(segment))
+		} else {
+			output = append(output, segment)
+		}
+	}
+
+	return completionResult(output, true)
+}
+
+// completeExt completes the extensions of the files in the session roots,
+// an extension is only offered when one of a few of its files may be read.
+func (c *MCPCompletion) completeExt(ctx context.Context, value string) *mcp.Completion {
+	if value != "" && !strings.HasPrefix(value, ".") {
+		value = "." + value
+	}
+	scopes := c.mcp.roots.Get(ctx)
+	exts, err := c.mcp.sql.ExtPrefix(value, COMPLETION_MAX*2, scopes...)
+	if err != nil {
+		return completionResult([]string{}, false)
+	}
+
+	output := make([]string, 0)
+	for _, ext := range exts {
+		if len(output) > COMPLETION_MAX {
+			break
+		}
+		paths, err := c.mcp.sql.ExtPaths(ext, COMPLETION_EXT_SAMPLE, scopes...)
+		if err != nil {
+			break
+		}
+		for _, path := range paths {
+			if c.mcp.allowed(ctx, path) {
+				output = append(output, ext)
+				break
+			}
+		}
+	}
+	return completionResult(output, len(exts) == COMPLETION_MAX*2)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var DUPLICATE_MIN_SIZE = 1024 * 1024
+var DUPLICATE_GROUP_LIMIT = 100
+
+func (s *MCPServer) duplicateToolInit() {
+	duplicateTool := mcp.NewTool(
+		"file_duplicates",
+		mcp.WithDescription("Find duplicate files in the index. Files are grouped by size, "+
+			"then by a hash of their first and last blocks, then by their full SHA-256. "+
+			"Returns the duplicate groups ordered by wasted bytes. "+
+			"Hashing may take long on large trees, progress notifications are sent "+
+			"and cancelling the request stops it."),
+		mcp.WithString("root",
+			mcp.Description("Only search under this directory, empty to search all indexed roots"),
+		),
+		mcp.WithNumber("min_size",
+			mcp.DefaultNumber(float64(DUPLICATE_MIN_SIZE)),
+			mcp.Description("The minimum file size in bytes"),
+		),
+		mcp.WithNumber("limit",
+			mcp.DefaultNumber(float64(DUPLICATE_GROUP_LIMIT)),
+			mcp.Description("The maximum number of groups to return"),
+		),
+	)
+
+	s.server.AddTool(duplicateTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		scopes := s.roots.Get(ctx)
+		root := request.GetString("root", "")
+		if root != "" {
+			root = filepath.Clean(root)
+			if !s.allowed(ctx, root) {
+				return nil, fmt.Errorf("path %s access denied", root)
+			}
+			scopes = []string{root}
+		}
+
+		minSize := request.GetFloat("min_size", float64(DUPLICATE_MIN_SIZE))
+		limit := request.GetFloat("limit", float64(DUPLICATE_GROUP_LIMIT))
+		if limit <= 0.0 {
+			limit = float64(DUPLICATE_GROUP_LIMIT)
+		}
+
+		logs.Info("mcp server find duplicates %v, min size %d", scopes, int64(minSize))
+
+		report, err := s.index.Duplicates(ctx, int64(minSize),
+			[]Progress{s.progressSink(ctx, request.Params.Meta)}, scopes...)
+		if err != nil {
+			logs.Error("mcp server find duplicates failed, %s", err.Error())
+			return nil, err
+		}
+
+		if len(report.Groups) > int(limit) {
+			report.Groups = report.Groups[:int(limit)]
+		}
+
+		return ResultToJSON(report)
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var API_EXPORTS_PATH = "/api/exports"
+
+func (s *MCPServer) exportToolInit() {
+	exportTool := mcp.NewTool("file_export", queryFilterOptions(
+		mcp.WithDescription("Export all files matching the filters of file_query to a CSV file "+
+			"in a background job and return the job. Follow the job with job_list, "+
+			"once done the file is downloaded from GET "+API_EXPORTS_PATH+"/<job target>."),
+	)...)
+
+	s.server.AddTool(exportTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		filter, err := queryFilterParse(request)
+		if err != nil {
+			return nil, err
+		}
+		filter.Scopes = s.roots.Get(ctx)
+
+		job, err := s.index.Export(filter, request.GetBool("details", false))
+		if err != nil {
+			logs.Error("mcp server export failed, %s", err.Error())
+			return nil, err
+		}
+
+		logs.Info("mcp server export %s, job %s", job.Target, job.ID)
+
+		snapshot, err := s.index.jobs.Get(job.ID)
+		if err != nil {
+			return nil, fmt.Errorf("export job %s lost, %s", job.ID, err.Error())
+		}
+		return ResultToJSON(snapshot)
+	})
+}
+
+func (s *MCPServer) exportHandlerInit(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+API_EXPORTS_PATH+"/{name}", s.serveExport)
+}
+
+func (s *MCPServer) serveExport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	path, err := ExportPath(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, path)
+}
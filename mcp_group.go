@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func (s *MCPServer) groupToolInit() {
+	groupTool := mcp.NewTool(
+		"file_ext_groups",
+		mcp.WithDescription("List the configured extension groups, such as documents or images, "+
+			"with their extensions. A group name can be passed as the group of file_query "+
+			"or written as group:<name> in its filename."),
+	)
+
+	s.server.AddTool(groupTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		groups := s.Config().ExtGroups
+		if groups == nil {
+			groups = []ExtGroup{}
+		}
+		return ResultToJSON(groups)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func ResultToJSON(value interface{}) (*mcp.CallToolResult, error) {
+	body, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("covert to json failed, %s", err.Error())
+	}
+	return mcp.NewToolResultText(string(body)), nil
+}
+
+func (s *MCPServer) indexToolInit() {
+	statusTool := mcp.NewTool(
+		"index_status",
+		mcp.WithDescription("Show the file index status: total and per root file counts, "+
+			"the last full scan time of each root, file watcher health with event overflows and errors, "+
+			"the depth of the pending change queue and the recent jobs."),
+		mcp.WithString("job_id",
+			mcp.Description("Only show the job with this id"),
+		),
+	)
+
+	rescanTool := mcp.NewTool(
+		"index_rescan",
+		mcp.WithDescription("Rescan the whole index or a subtree in the background, "+
+			"new files are added and files no longer on disk are removed. "+
+			"Returns a job id, poll index_status with the job id until the job is done, "+
+			"or set wait to block until the job is done with progress notifications."),
+		mcp.WithString("path",
+			mcp.Description("The directory to rescan, empty to rescan all indexed roots"),
+		),
+		mcp.WithBoolean("wait",
+			mcp.DefaultBool(false),
+			mcp.Description("Wait for the rescan to finish, cancelling the request only stops waiting, use job_cancel to stop the rescan"),
+		),
+	)
+
+	s.server.AddTool(statusTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		jobID := request.GetString("job_id", "")
+		if jobID != "" {
+			job, err := s.index.jobs.Get(jobID)
+			if err != nil {
+				return nil, err
+			}
+			return ResultToJSON(job)
+		}
+
+		logs.Info("mcp server index status")
+
+		return ResultToJSON(s.index.IndexStatus())
+	})
+
+	s.server.AddTool(rescanTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		path := request.GetString("path", "")
+		if path == "" && len(s.roots.Get(ctx)) > 0 {
+			return nil, fmt.Errorf("path is required within the session roots")
+		}
+		if path != "" && !s.allowed(ctx, path) {
+			return nil, fmt.Errorf("path %s access denied", path)
+		}
+
+		if !request.GetBool("wait", false) {
+			job, err := s.index.IndexRescan(path)
+			if err != nil {
+				logs.Error("mcp server index rescan failed, %s", err.Error())
+				return nil, err
+			}
+			logs.Info("mcp server index rescan %s, job %s", path, job.ID)
+			return ResultToJSON(map[string]string{"job_id": job.ID})
+		}
+
+		job, err := s.index.IndexRescan(path, s.progressSink(ctx, request.Params.Meta))
+		if err != nil {
+			logs.Error("mcp server index rescan failed, %s", err.Error())
+			return nil, err
+		}
+
+		logs.Info("mcp server index rescan %s, job %s, wait", path, job.ID)
+
+		err = job.Wait(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("stop waiting for rescan job %s which keeps running, %s", job.ID, err.Error())
+		}
+
+		result, err := s.index.jobs.Get(job.ID)
+		if err != nil {
+			return nil, err
+		}
+		return ResultToJSON(result)
+	})
+}
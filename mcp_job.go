@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var API_JOBS_PATH = "/api/jobs"
+var JOB_LIST_LIMIT = 50
+
+func (s *MCPServer) jobToolInit() {
+	listTool := mcp.NewTool(
+		"job_list",
+		mcp.WithDescription("List the background jobs (rescans, rebuilds, hashing, exports) newest first, "+
+			"with their state, progress, result, error, start and end time. "+
+			"The history is kept across restarts."),
+		mcp.WithString("state",
+			mcp.Enum("running", "done", "failed", "cancelled", "interrupted"),
+			mcp.Description("Only list the jobs in this state"),
+		),
+		mcp.WithString("kind",
+			mcp.Description("Only list the jobs of this kind, such as rescan or rebuild"),
+		),
+		mcp.WithNumber("limit",
+			mcp.DefaultNumber(float64(JOB_LIST_LIMIT)),
+			mcp.Description("The maximum number of jobs to return"),
+		),
+	)
+
+	cancelTool := mcp.NewTool(
+		"job_cancel",
+		mcp.WithDescription("Cancel a running background job."),
+		mcp.WithString("job_id",
+			mcp.Required(),
+			mcp.Description("The id of the job to cancel"),
+		),
+	)
+
+	s.server.AddTool(listTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		limit := request.GetFloat("limit", float64(JOB_LIST_LIMIT))
+		if limit <= 0.0 {
+			limit = float64(JOB_LIST_LIMIT)
+		}
+
+		jobs, err := s.index.jobs.List(request.GetString("state", ""), request.GetString("kind", ""), int(limit))
+		if err != nil {
+			return nil, err
+		}
+
+		logs.Info("mcp server job list, %d jobs", len(jobs))
+
+		return ResultToJSON(jobs)
+	})
+
+	s.server.AddTool(cancelTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		jobID, err := request.RequireString("job_id")
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.index.jobs.Cancel(jobID)
+		if err != nil {
+			return nil, err
+		}
+
+		logs.Info("mcp server job cancel %s", jobID)
+
+		return mcp.NewToolResultText("ok"), nil
+	})
+}
+
+func (s *MCPServer) jobHandlerInit(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+API_JOBS_PATH, s.serveJobList)
+	mux.HandleFunc("GET "+API_JOBS_PATH+"/{id}", s.serveJobGet)
+	mux.HandleFunc("POST "+API_JOBS_PATH+"/{id}/cancel", s.serveJobCancel)
+}
+
+func (s *MCPServer) serveJobList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	jobs, err := s.index.jobs.List(query.Get("state"), query.Get("kind"), JOB_LIST_LIMIT)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveJSON(w, jobs)
+}
+
+func (s *MCPServer) serveJobGet(w http.ResponseWriter, r *http.Request) {
+	job, err := s.index.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	serveJSON(w, job)
+}
+
+func (s *MCPServer) serveJobCancel(w http.ResponseWriter, r *http.Request) {
+	err := s.index.jobs.Cancel(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	serveJSON(w, map[string]string{"status": "ok"})
+}
+
+func serveJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		logs.Warning("write json response failed, %s", err.Error())
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var API_CHANGES_PATH = "/api/changes"
+var CHANGES_LIMIT = 200
+var CHANGES_SINCE = time.Hour
+
+// ParseTimeArg accepts a RFC3339 time, a local "2006-01-02 15:04:05" time
+// or a duration such as 30m or 2h meaning that long ago.
+func ParseTimeArg(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return def, fmt.Errorf("invalid time %s, use RFC3339, 2006-01-02 15:04:05 or a duration like 30m", value)
+}
+
+func changeFilterParse(since, until, events, pattern string) (ChangeFilter, error) {
+	var filter ChangeFilter
+	var err error
+
+	filter.Since, err = ParseTimeArg(since, time.Now().Add(-CHANGES_SINCE))
+	if err != nil {
+		return filter, err
+	}
+	filter.Until, err = ParseTimeArg(until, time.Now())
+	if err != nil {
+		return filter, err
+	}
+	for _, v := range strings.Split(events, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if v != CHANGE_ADD && v != CHANGE_MODIFY && v != CHANGE_REMOVE && v != CHANGE_RENAME && v != CHANGE_RESCAN {
+			return filter, fmt.Errorf("invalid event %s, use add, modify, remove, rename or rescan", v)
+		}
+		filter.Events = append(filter.Events, v)
+	}
+	filter.Pattern = pattern
+	return filter, nil
+}
+
+func (s *MCPServer) journalToolInit() {
+	changesTool := mcp.NewTool(
+		"recent_changes",
+		mcp.WithDescription("List the file changes seen by the file watcher, newest first: "+
+			"added, modified, removed and renamed files with their time, size and old path. "+
+			"A rescan entry marks a directory rewritten by a scan, its changes are not listed one by one. "+
+			"Useful to find out what a build or a program just touched."),
+		mcp.WithString("since",
+			mcp.Description("Start of the time range, RFC3339, 2006-01-02 15:04:05 or a duration like 30m meaning that long ago, default 1h"),
+		),
+		mcp.WithString("until",
+			mcp.Description("End of the time range in the same formats, default now"),
+		),
+		mcp.WithString("root",
+			mcp.Description("Only list changes under this directory"),
+		),
+		mcp.WithString("event",
+			mcp.Description("Comma separated event types to list: add, modify, remove, rename, rescan"),
+		),
+		mcp.WithString("pattern",
+			mcp.Description("Only list paths matching this GLOB pattern or containing this text"),
+		),
+		mcp.WithNumber("limit",
+			mcp.DefaultNumber(float64(CHANGES_LIMIT)),
+			mcp.Description("The maximum number of changes to return"),
+		),
+	)
+
+	s.server.AddTool(changesTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		filter, err := changeFilterParse(request.GetString("since", ""), request.GetString("until", ""),
+			request.GetString("event", ""), request.GetString("pattern", ""))
+		if err != nil {
+			return nil, err
+		}
+
+		filter.Scopes = s.roots.Get(ctx)
+		root := request.GetString("root", "")
+		if root != "" {
+			root = filepath.Clean(root)
+			if !s.allowed(ctx, root) {
+				return nil, fmt.Errorf("path %s access denied", root)
+			}
+			filter.Scopes = []string{root}
+		}
+
+		filter.Limit = int(request.GetFloat("limit", float64(CHANGES_LIMIT)))
+		if filter.Limit <= 0 {
+			filter.Limit = CHANGES_LIMIT
+		}
+
+		changes, err := s.sql.Changes(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		logs.Info("mcp server recent changes since %s, %d changes", filter.Since.Format(time.RFC3339), len(changes))
+
+		return ResultToJSON(changes)
+	})
+}
+
+func (s *MCPServer) serveChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := changeFilterParse(query.Get("since"), query.Get("until"), query.Get("event"), query.Get("pattern"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if root := query.Get("root"); root != "" {
+		filter.Scopes = []string{filepath.Clean(root)}
+	}
+
+	filter.Limit = CHANGES_LIMIT
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		filter.Limit = limit
+	}
+
+	changes, err := s.sql.Changes(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveJSON(w, changes)
+}
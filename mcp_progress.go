@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var API_PROGRESS_PATH = "/api/progress"
+
+// progressSink returns a sink sending notifications/progress to the client
+// of the request, or nil when the request has no progress token.
+func (s *MCPServer) progressSink(ctx context.Context, meta *mcp.Meta) Progress {
+	if meta == nil || meta.ProgressToken == nil {
+		return nil
+	}
+	token := meta.ProgressToken
+
+	return ProgressFunc(func(current, total int64, message string) {
+		params := map[string]any{
+			"progressToken": token,
+			"progress":      current,
+		}
+		if total > 0 {
+			params["total"] = total
+		}
+		if message != "" {
+			params["message"] = message
+		}
+		err := s.server.SendNotificationToClient(ctx, string(mcp.MethodNotificationProgress), params)
+		if err != nil {
+			logs.Warning("mcp progress notify failed, %s", err.Error())
+		}
+	})
+}
+
+// serveProgress lists the operations in progress as JSON.
+func (s *MCPServer) serveProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	serveJSON(w, ProgressList())
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// promptRoots lists the roots the session may access, the enabled drives
+// when the client declared no roots.
+func (s *MCPServer) promptRoots(ctx context.Context) string {
+	roots := s.roots.Get(ctx)
+	if len(roots) == 0 {
+		roots = enabledDrives(s.Config())
+	}
+	return strings.Join(roots, ", ")
+}
+
+func (s *MCPServer) promptTools() string {
+	tools := make([]string, 0)
+	for name := range s.server.ListTools() {
+		tools = append(tools, name)
+	}
+	sort.Strings(tools)
+	return strings.Join(tools, ", ")
+}
+
+func (s *MCPServer) addPrompt(prompt PromptConfig) error {
+	tmpl, err := template.New(prompt.Name).Option("missingkey=zero").
+		Funcs(template.FuncMap{"uri": FileURI}).Parse(prompt.Template)
+	if err != nil {
+		return fmt.Errorf("parse prompt %s template failed, %s", prompt.Name, err.Error())
+	}
+
+	options := []mcp.PromptOption{mcp.WithPromptDescription(prompt.Description)}
+	for _, arg := range prompt.Arguments {
+		argOptions := []mcp.ArgumentOption{mcp.ArgumentDescription(arg.Description)}
+		if arg.Required {
+			argOptions = append(argOptions, mcp.RequiredArgument())
+		}
+		options = append(options, mcp.WithArgument(arg.Name, argOptions...))
+	}
+
+	s.server.AddPrompt(mcp.NewPrompt(prompt.Name, options...), func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		data := map[string]string{
+			"roots": s.promptRoots(ctx),
+			"tools": s.promptTools(),
+		}
+		for _, arg := range prompt.Arguments {
+			value := request.Params.Arguments[arg.Name]
+			if arg.Required && value == "" {
+				return nil, fmt.Errorf("prompt %s argument %s is required", prompt.Name, arg.Name)
+			}
+			data[arg.Name] = value
+		}
+
+		var text strings.Builder
+		err := tmpl.Execute(&text, data)
+		if err != nil {
+			return nil, fmt.Errorf("render prompt %s failed, %s", prompt.Name, err.Error())
+		}
+
+		logs.Info("mcp server get prompt %s", prompt.Name)
+
+		return mcp.NewGetPromptResult(prompt.Description, []mcp.PromptMessage{
+			mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text.String())),
+		}), nil
+	})
+	return nil
+}
+
+func (s *MCPServer) promptInit() {
+	for _, prompt := range PromptsLoad() {
+		err := s.addPrompt(prompt)
+		if err != nil {
+			logs.Warning("mcp server add prompt failed, %s", err.Error())
+		}
+	}
+}
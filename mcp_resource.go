@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+var MCP_RESOURCE_TEMPLATE = "file:///{+path}"
+
+func FileURI(path string) string {
+	u := url.URL{Scheme: "file", Path: "/" + filepath.ToSlash(path)}
+	return u.String()
+}
+
+func FileURIPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("parse uri %s failed, %s", uri, err.Error())
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported uri scheme %s", u.Scheme)
+	}
+	return filepath.Clean(filepath.FromSlash(strings.TrimPrefix(u.Path, "/"))), nil
+}
+
+func FileMIMEType(path string, head []byte) string {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if mimeType == "" && len(head) > 0 {
+		mimeType = http.DetectContentType(head)
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return mimeType
+}
+
+func IsTextMIMEType(mimeType string) bool {
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	for _, v := range []string{"json", "xml", "javascript", "yaml", "toml", "x-sh"} {
+		if strings.Contains(mimeType, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MCPServer) fileResource(file FileInfo) mcp.Resource {
+	return mcp.NewResource(FileURI(file.Path), file.Name,
+		mcp.WithResourceDescription(file.Path),
+		mcp.WithMIMEType(FileMIMEType(file.Path, nil)),
+		mcp.WithResourceSize(file.Size),
+		mcp.WithLastModified(file.ModTime.Format(time.RFC3339)),
+	)
+}
+
+func (s *MCPServer) refreshResources() {
+	resources := make([]server.ServerResource, 0)
+	exist := make(map[string]bool)
+	config := s.Config()
+
+	for _, path := range config.ResourcePinned {
+		file, err := NewFileInfo(path)
+		if err != nil {
+			logs.Warning("pinned resource %s not found, %s", path, err.Error())
+			continue
+		}
+		resource := s.fileResource(*file)
+		exist[resource.URI] = true
+		resources = append(resources, server.ServerResource{Resource: resource, Handler: s.readResource})
+	}
+
+	if config.ResourceRecent > 0 {
+		files, err := s.sql.Recent(config.ResourceRecent)
+		if err != nil {
+			logs.Warning("query recent resources failed, %s", err.Error())
+		}
+		for _, file := range files {
+			if !config.CheckAccess(file.Path) {
+				continue
+			}
+			resource := s.fileResource(file)
+			if exist[resource.URI] {
+				continue
+			}
+			exist[resource.URI] = true
+			resources = append(resources, server.ServerResource{Resource: resource, Handler: s.readResource})
+		}
+	}
+
+	s.server.SetResources(resources...)
+}
+
+func (s *MCPServer) readResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	path, err := FileURIPath(request.Params.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.allowed(ctx, path) {
+		logs.Warning("mcp resource %s access denied", path)
+		return nil, fmt.Errorf("resource %s access denied", request.Params.URI)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("resource %s not found, %s", request.Params.URI, err.Error())
+	}
+
+	if info.IsDir() {
+		return s.readDirResource(ctx, request.Params.URI, path)
+	}
+
+	maxSize := s.Config().ResourceMaxSize
+	if maxSize > 0 && info.Size() > maxSize {
+		return nil, fmt.Errorf("resource %s size %s exceeds limit %s",
+			request.Params.URI, ByteView(info.Size()), ByteView(maxSize))
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read resource %s failed, %s", request.Params.URI, err.Error())
+	}
+
+	logs.Info("mcp read resource %s, size %d", path, len(body))
+
+	mimeType := FileMIMEType(path, body)
+
+	if IsTextMIMEType(mimeType) || (mimeType == "application/octet-stream" && utf8.Valid(body)) {
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{URI: request.Params.URI, MIMEType: mimeType, Text: string(body)},
+		}, nil
+	}
+
+	return []mcp.ResourceContents{
+		mcp.BlobResourceContents{URI: request.Params.URI, MIMEType: mimeType, Blob: base64.StdEncoding.EncodeToString(body)},
+	}, nil
+}
+
+func (s *MCPServer) readDirResource(ctx context.Context, uri string, path string) ([]mcp.ResourceContents, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("read directory %s failed, %s", uri, err.Error())
+	}
+
+	files := make([]FileInfo, 0)
+	for _, entry := range entries {
+		file, err := NewFileInfo(filepath.Join(path, entry.Name()))
+		if err != nil || !s.allowed(ctx, file.Path) {
+			continue
+		}
+		files = append(files, *file)
+	}
+
+	if len(files) == 0 {
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{URI: uri, MIMEType: "text/csv", Text: ""},
+		}, nil
+	}
+
+	csvText, err := ResultToCSV(files)
+	if err != nil {
+		return nil, fmt.Errorf("covert to csv failed, %s", err.Error())
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{URI: uri, MIMEType: "text/csv", Text: csvText},
+	}, nil
+}
+
+func (s *MCPServer) allowed(ctx context.Context, path string) bool {
+	config := s.Config()
+	return config.CheckAccess(path) && s.roots.Allowed(ctx, path)
+}
+
+// filterResources limits a resources/list result to the session roots.
+func (s *MCPServer) filterResources(ctx context.Context, result *mcp.ListResourcesResult) {
+	resources := make([]mcp.Resource, 0, len(result.Resources))
+	for _, resource := range result.Resources {
+		path, err := FileURIPath(resource.URI)
+		if err != nil || !s.roots.Allowed(ctx, path) {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	result.Resources = resources
+}
+
+func (s *MCPServer) resourceInit() {
+	template := mcp.NewResourceTemplate(MCP_RESOURCE_TEMPLATE, "file",
+		mcp.WithTemplateDescription("A file or directory under the indexed drives, "+
+			"directories are returned as a CSV listing of their entries."),
+	)
+
+	s.server.AddResourceTemplate(template, s.readResource)
+
+	s.refreshResources()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+var ROOTS_REQUEST_TIMEOUT = 10 * time.Second
+
+// SessionRoots keeps the roots advertised by each mcp client session, queries
+// of a session with roots are limited to those directories.
+type SessionRoots struct {
+	sync.RWMutex
+
+	server *server.MCPServer
+	roots  map[string][]string // session id -> root paths
+}
+
+func NewSessionRoots(s *server.MCPServer) *SessionRoots {
+	return &SessionRoots{server: s, roots: make(map[string][]string)}
+}
+
+func (r *SessionRoots) Hooks(hooks *server.Hooks) {
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		r.Lock()
+		defer r.Unlock()
+		delete(r.roots, session.SessionID())
+	})
+}
+
+func (r *SessionRoots) Handlers() {
+	refresh := func(ctx context.Context, notification mcp.JSONRPCNotification) {
+		// the roots response comes in on the same transport, never block it
+		go r.Refresh(context.WithoutCancel(ctx))
+	}
+	r.server.AddNotificationHandler(string(mcp.MethodNotificationInitialized), refresh)
+	r.server.AddNotificationHandler(mcp.MethodNotificationRootsListChanged, refresh)
+}
+
+func (r *SessionRoots) Refresh(ctx context.Context) {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return
+	}
+
+	if info, ok := session.(server.SessionWithClientInfo); ok && info.GetClientCapabilities().Roots == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ROOTS_REQUEST_TIMEOUT)
+	defer cancel()
+
+	result, err := r.server.RequestRoots(ctx, mcp.ListRootsRequest{})
+	if err != nil {
+		logs.Info("mcp session %s list roots failed, %s", session.SessionID(), err.Error())
+		return
+	}
+
+	paths := make([]string, 0)
+	for _, root := range result.Roots {
+		path, err := FileURIPath(root.URI)
+		if err != nil {
+			logs.Warning("mcp session %s root %s invalid, %s", session.SessionID(), root.URI, err.Error())
+			continue
+		}
+		// clients commonly send lower case drive letters
+		if len(path) >= 2 && path[1] == ':' {
+			path = strings.ToUpper(path[:1]) + path[1:]
+		}
+		paths = append(paths, path)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if len(paths) == 0 {
+		delete(r.roots, session.SessionID())
+	} else {
+		r.roots[session.SessionID()] = paths
+	}
+
+	logs.Info("mcp session %s roots: %v", session.SessionID(), paths)
+}
+
+func (r *SessionRoots) Get(ctx context.Context) []string {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return nil
+	}
+
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.roots[session.SessionID()]
+}
+
+// Allowed reports whether path lies within the roots of the session.
+func (r *SessionRoots) Allowed(ctx context.Context, path string) bool {
+	roots := r.Get(ctx)
+	if len(roots) == 0 {
+		return true
+	}
+	for _, root := range roots {
+		if PathWithin(path, root) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
@@ -15,57 +16,119 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 )
 
+var MCP_STREAMABLE_PATH = "/mcp"
+
 type MCPServer struct {
 	sync.WaitGroup
 
-	server     *server.MCPServer
+	server *server.MCPServer
+
+	httpLock   sync.RWMutex
 	sse        *server.SSEServer
+	streamable *server.StreamableHTTPServer
+	mux        *http.ServeMux
 	httpserver *http.Server
 	sql        *SQLiteDB
+	index      *Server
+	subscriber *ResourceSubscriber
+	roots      *SessionRoots
+	feed       *ChangeFeed
+	unlisten   func()
 }
 
 func ResultToCSV(files []FileInfo) (string, error) {
+	return resultToCSV(files, false)
+}
+
+func resultToCSV(files []FileInfo, details bool) (string, error) {
 	var csvBuf strings.Builder
-	writer := csv.NewWriter(&csvBuf)
+	err := writeCSV(&csvBuf, files, details)
+	if err != nil {
+		return "", err
+	}
+	return csvBuf.String(), nil
+}
+
+// writeCSV writes the header and a row per file to w.
+func writeCSV(w io.Writer, files []FileInfo, details bool) error {
+	writer := csv.NewWriter(w)
 
-	if err := writer.Write(files[0].ToHeader()); err != nil {
-		return "", fmt.Errorf("failed to write headers: %v", err)
+	var head FileInfo
+	header := head.ToHeader()
+	if details {
+		header = append(header, head.ToDetailHeader()...)
+	}
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("failed to write headers: %v", err)
 	}
 
 	for _, item := range files {
-		if err := writer.Write(item.ToList()); err != nil {
-			return "", fmt.Errorf("failed to write row: %v", err)
+		row := item.ToList()
+		if details {
+			row = append(row, item.ToDetailList()...)
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("failed to write row: %v", err)
 		}
 	}
 
 	writer.Flush()
 	if err := writer.Error(); err != nil {
-		return "", fmt.Errorf("error flushing CSV writer: %v", err)
+		return fmt.Errorf("error flushing CSV writer: %v", err)
 	}
-
-	return csvBuf.String(), nil
+	return nil
 }
 
-func NewMCPServer(s *SQLiteDB) *MCPServer {
+func NewMCPServer(index *Server) *MCPServer {
+	s := index.sql
+	m := &MCPServer{
+		sql:   s,
+		index: index,
+	}
+
+	hooks := &server.Hooks{}
+	hooks.AddBeforeListResources(func(ctx context.Context, id any, message *mcp.ListResourcesRequest) {
+		m.refreshResources()
+	})
+	hooks.AddAfterListResources(func(ctx context.Context, id any, message *mcp.ListResourcesRequest, result *mcp.ListResourcesResult) {
+		m.filterResources(ctx, result)
+	})
+
+	completion := &MCPCompletion{mcp: m}
+
 	mcpServer := server.NewMCPServer(
 		APPLICATION_NAME,
 		APPLICATION_VERSION,
+		server.WithHooks(hooks),
+		// a read-only index has no notify task, subscribe is refused
+		server.WithResourceCapabilities(!s.ReadOnly(), false),
+		server.WithPromptCapabilities(false),
+		server.WithCompletions(),
+		server.WithPromptCompletionProvider(completion),
+		server.WithResourceCompletionProvider(completion),
 	)
+	m.server = mcpServer
+
+	m.subscriber = NewResourceSubscriber(mcpServer, m.allowed)
+	m.subscriber.Hooks(hooks)
+	m.unlisten = s.AddListener(m.subscriber.FileChanged)
+	m.feed = NewChangeFeed(s)
 
-	queryTool := mcp.NewTool(
-		"file_query",
+	m.roots = NewSessionRoots(mcpServer)
+	m.roots.Hooks(hooks)
+	m.roots.Handlers()
+
+	queryTool := mcp.NewTool("file_query", append(queryFilterOptions(
 		mcp.WithDescription("Execute a file search operation. "+
 			" Make sure you have a filename or keyword before executing the query. "+
 			" Make sure you have knowledge of SQLite3 query rules, "+
-			" please use the `LIKE` or `GLOB` query rules."),
-		mcp.WithString("filename",
-			mcp.Required(),
-			mcp.Description("The filename as a keyword to query"),
-		),
+			" please use the `LIKE` or `GLOB` query rules."+
+			" The filename may also hold type:<category or mime type> and category:<category> terms,"+
+			" such as `report type:document` or `type:image/png`, and group:<extension group> terms.")),
 		mcp.WithNumber("limit",
 			mcp.DefaultNumber(100),
 			mcp.Description("The maximum number of results to return"),
-		),
+		))...,
 	)
 
 	openTool := mcp.NewTool(
@@ -87,19 +150,25 @@ func NewMCPServer(s *SQLiteDB) *MCPServer {
 
 		logs.Info("mcp server start query tools")
 
-		limit, ok := request.Params.Arguments["limit"].(float64)
-		if !ok || limit <= 0.0 {
+		limit := request.GetFloat("limit", 100.0)
+		if limit <= 0.0 {
 			limit = 100.0
 		}
 
-		filename, ok := request.Params.Arguments["filename"].(string)
-		if !ok || len(filename) == 0 {
-			return nil, fmt.Errorf("filename is empty")
+		filter, err := queryFilterParse(request)
+		if err != nil {
+			return nil, err
 		}
+		filter.Limit = int(limit)
+		filter.Scopes = m.roots.Get(ctx)
+
+		filename := filter.Keyword
 
 		logs.Info("mcp server start query filename: %s, limit: %d", filename, int(limit))
 
-		fileInfos, err := s.Query(filename, int(limit))
+		progress := StartProgress("mcp query "+filename, m.progressSink(ctx, request.Params.Meta))
+		fileInfos, err := m.index.Find(ctx, progress, filter)
+		progress.Done(fmt.Sprintf("%d files", len(fileInfos)))
 		if err != nil {
 			logs.Error("mcp server query failed, %s", err.Error())
 
@@ -113,7 +182,7 @@ func NewMCPServer(s *SQLiteDB) *MCPServer {
 
 		logs.Info("mcp server start query filename: %s, number: %d", filename, len(fileInfos))
 
-		csvText, err := ResultToCSV(fileInfos)
+		csvText, err := resultToCSV(fileInfos, request.GetBool("details", false))
 		if err != nil {
 			return nil, fmt.Errorf("covert to csv failed, %s", err.Error())
 		}
@@ -128,43 +197,195 @@ func NewMCPServer(s *SQLiteDB) *MCPServer {
 			}
 		}()
 
-		err := OpenBrowserWeb(request.Params.Arguments["filename"].(string))
+		filename, err := request.RequireString("filename")
+		if err != nil {
+			return nil, err
+		}
+
+		err = OpenBrowserWeb(filename)
 		if err != nil {
 			return nil, err
 		}
 		return mcp.NewToolResultText("ok"), nil
 	})
 
-	return &MCPServer{
-		server: mcpServer,
-		sql:    s,
+	m.indexToolInit()
+	m.jobToolInit()
+	m.duplicateToolInit()
+	m.exportToolInit()
+	m.usageToolInit()
+	m.groupToolInit()
+	m.journalToolInit()
+	m.resourceInit()
+	m.promptInit()
+
+	return m
+}
+
+// queryFilterOptions appends the filter parameters read by
+// queryFilterParse to options.
+func queryFilterOptions(options ...mcp.ToolOption) []mcp.ToolOption {
+	return append(options,
+		mcp.WithString("filename",
+			mcp.Description("The filename as a keyword to query, may be empty when another filter is given"),
+		),
+		mcp.WithString("ext",
+			mcp.Description("Only files with this extension, such as .pdf"),
+		),
+		mcp.WithNumber("min_size",
+			mcp.Description("Only files of at least this many bytes"),
+		),
+		mcp.WithNumber("max_size",
+			mcp.Description("Only files of at most this many bytes"),
+		),
+		mcp.WithString("modified_after",
+			mcp.Description("Only files modified after this time, RFC3339, 2006-01-02 15:04:05 or a duration like 24h meaning that long ago"),
+		),
+		mcp.WithString("modified_before",
+			mcp.Description("Only files modified before this time, same formats as modified_after"),
+		),
+		mcp.WithString("group",
+			mcp.Description("Only files with an extension of this configured group, such as documents or images, see file_ext_groups"),
+		),
+		mcp.WithString("category",
+			mcp.Enum(CLASSIFY_CATEGORIES...),
+			mcp.Description("Only files of this category, detected from their content in the background"),
+		),
+		mcp.WithString("owner",
+			mcp.Description("Only files of this owner, an account name like DOMAIN\\user or a SID"),
+		),
+		mcp.WithBoolean("details",
+			mcp.DefaultBool(false),
+			mcp.Description("Append creation, change and access time, mode, owner, group, inode, device, link count, link target, mime type and category"),
+		),
+		mcp.WithString("order",
+			mcp.Enum("name", "recent", "largest"),
+			mcp.DefaultString("name"),
+			mcp.Description("recent lists the newest files first, largest the biggest first"),
+		),
+	)
+}
+
+// queryFilterParse reads the filters of the file_query tool, a query needs
+// a filename or another filter.
+func queryFilterParse(request mcp.CallToolRequest) (QueryFilter, error) {
+	var err error
+
+	filter := QueryFilter{
+		Keyword:  request.GetString("filename", ""),
+		Ext:      request.GetString("ext", ""),
+		MinSize:  int64(request.GetFloat("min_size", 0)),
+		MaxSize:  int64(request.GetFloat("max_size", 0)),
+		Owner:    request.GetString("owner", ""),
+		Category: request.GetString("category", ""),
+		Order:    request.GetString("order", "name"),
+	}
+	if group := request.GetString("group", ""); group != "" {
+		filter.Groups = append(filter.Groups, group)
+	}
+	filter.ModifiedAfter, err = ParseTimeArg(request.GetString("modified_after", ""), time.Time{})
+	if err != nil {
+		return filter, err
+	}
+	filter.ModifiedBefore, err = ParseTimeArg(request.GetString("modified_before", ""), time.Time{})
+	if err != nil {
+		return filter, err
+	}
+
+	if filter.Keyword == "" && filter.Ext == "" && filter.MinSize <= 0 && filter.MaxSize <= 0 && filter.Owner == "" && filter.Category == "" && len(filter.Groups) == 0 &&
+		filter.ModifiedAfter.IsZero() && filter.ModifiedBefore.IsZero() {
+		return filter, fmt.Errorf("filename is empty")
 	}
+	return filter, nil
+}
+
+func (s *MCPServer) Config() Config {
+	return s.index.Config()
 }
 
 func (s *MCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.sse.ServeHTTP(w, r)
+	s.httpLock.RLock()
+	mux := s.mux
+	s.httpLock.RUnlock()
+
+	if mux == nil {
+		http.Error(w, "mcp server stopped", http.StatusServiceUnavailable)
+		return
+	}
+	mux.ServeHTTP(w, r)
 }
 
-func (s *MCPServer) Startup(addr string, port int) error {
-	var address string
+func mcpAddress(addr string, port int) string {
 	if strings.Contains(addr, ":") {
-		address = fmt.Sprintf("[%s]:%d", addr, port)
-	} else {
-		address = fmt.Sprintf("%s:%d", addr, port)
+		return fmt.Sprintf("[%s]:%d", addr, port)
+	}
+	return fmt.Sprintf("%s:%d", addr, port)
+}
+
+func (s *MCPServer) Startup(addr string, port int, sseEnable, streamableEnable bool) error {
+	if !sseEnable && !streamableEnable {
+		return fmt.Errorf("no mcp transport enabled")
 	}
 
+	address := mcpAddress(addr, port)
+
 	listen, err := net.Listen("tcp", address)
 	if err != nil {
 		logs.Error("http file server listen %s address fail", address)
 		return err
 	}
 
-	s.httpserver = &http.Server{
+	s.serve(listen, address, sseEnable, streamableEnable)
+	return nil
+}
+
+// transports creates the enabled transports and the mux serving them along
+// with the http apis.
+func (s *MCPServer) transports(sseEnable, streamableEnable bool) (*server.SSEServer, *server.StreamableHTTPServer, *http.ServeMux) {
+	var sse *server.SSEServer
+	var streamable *server.StreamableHTTPServer
+
+	mux := http.NewServeMux()
+
+	if sseEnable {
+		sse = server.NewSSEServer(s.server)
+		mux.Handle(sse.CompleteSsePath(), sse)
+		mux.Handle(sse.CompleteMessagePath(), sse)
+		logs.Info("mcp sse transport on %s", sse.CompleteSsePath())
+	}
+
+	if streamableEnable {
+		streamable = server.NewStreamableHTTPServer(s.server,
+			server.WithStateful(true),
+			server.WithEventStore(server.NewInMemoryEventStore()),
+			server.WithSessionIdleTTL(30*time.Minute),
+			server.WithHeartbeatInterval(30*time.Second),
+		)
+		mux.Handle(MCP_STREAMABLE_PATH, streamable)
+		logs.Info("mcp streamable http transport on %s", MCP_STREAMABLE_PATH)
+	}
+
+	mux.HandleFunc(API_PROGRESS_PATH, s.serveProgress)
+	s.jobHandlerInit(mux)
+	s.exportHandlerInit(mux)
+	mux.HandleFunc("GET "+API_CHANGES_PATH, s.serveChanges)
+	mux.HandleFunc("GET "+API_FEED_PATH, s.feed.servePoll)
+	mux.HandleFunc("GET "+API_FEED_EVENTS_PATH, s.feed.serveEvents)
+
+	return sse, streamable, mux
+}
+
+// serve serves the transports on listen, which is bound to address.
+func (s *MCPServer) serve(listen net.Listener, address string, sseEnable, streamableEnable bool) {
+	sse, streamable, mux := s.transports(sseEnable, streamableEnable)
+	httpserver := &http.Server{
 		Addr:    address,
 		Handler: s,
 	}
 
-	s.sse = server.NewSSEServer(s.server, server.WithHTTPServer(s.httpserver))
+	s.httpLock.Lock()
+	s.sse, s.streamable, s.mux, s.httpserver = sse, streamable, mux, httpserver
+	s.httpLock.Unlock()
 
 	logs.Info("http file server listening on %s", address)
 
@@ -172,22 +393,95 @@ func (s *MCPServer) Startup(addr string, port int) error {
 
 	go func() {
 		defer s.Done()
-		err = s.httpserver.Serve(listen)
-		if err != nil {
+		err := httpserver.Serve(listen)
+		if err != nil && err != http.ErrServerClosed {
 			logs.Warning("httpserver serv failed, %s", err.Error())
 		}
 	}()
+}
+
+func (s *MCPServer) ServeStdio(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+	return server.NewStdioServer(s.server).Listen(ctx, stdin, stdout)
+}
+
+// Rebind moves the http server to the new address and transports, the
+// tools, subscriptions and feed are kept. The new address is bound before
+// the old listener stops, the old one keeps serving when it fails. On the
+// same address the listener is kept and only the transports are swapped.
+func (s *MCPServer) Rebind(addr string, port int, sseEnable, streamableEnable bool) error {
+	if !sseEnable && !streamableEnable {
+		return fmt.Errorf("no mcp transport enabled")
+	}
 
+	address := mcpAddress(addr, port)
+	logs.Info("mcp server rebind %s", address)
+
+	s.httpLock.Lock()
+	if s.httpserver != nil && s.httpserver.Addr == address {
+		oldSSE, oldStreamable := s.sse, s.streamable
+		s.sse, s.streamable, s.mux = s.transports(sseEnable, streamableEnable)
+		s.httpLock.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		transportsClose(ctx, oldSSE, oldStreamable)
+		return nil
+	}
+	s.httpLock.Unlock()
+
+	listen, err := net.Listen("tcp", address)
+	if err != nil {
+		logs.Error("http file server listen %s address fail", address)
+		return err
+	}
+
+	s.stopHTTP()
+	s.serve(listen, address, sseEnable, streamableEnable)
 	return nil
 }
 
 func (s *MCPServer) Shutdown() {
 	logs.Info("mcp server ready to shutdown")
-	context, cencel := context.WithTimeout(context.Background(), 5*time.Second)
-	err := s.sse.Shutdown(context)
-	cencel()
-	if err != nil {
-		logs.Warning("mcp server shutdown failed, %s", err.Error())
+
+	s.feed.Close()
+	s.stopHTTP()
+
+	s.unlisten()
+	s.subscriber.Close()
+}
+
+// transportsClose ends the sessions of the transports, they do not own the
+// http server.
+func transportsClose(ctx context.Context, sse *server.SSEServer, streamable *server.StreamableHTTPServer) {
+	if streamable != nil {
+		err := streamable.Shutdown(ctx)
+		if err != nil {
+			logs.Warning("mcp streamable server shutdown failed, %s", err.Error())
+		}
+	}
+	if sse != nil {
+		sse.CloseSessions()
+	}
+}
+
+func (s *MCPServer) stopHTTP() {
+	context, cencel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cencel()
+
+	s.httpLock.Lock()
+	sse, streamable, httpserver := s.sse, s.streamable, s.httpserver
+	s.sse, s.streamable, s.mux, s.httpserver = nil, nil, nil, nil
+	s.httpLock.Unlock()
+
+	transportsClose(context, sse, streamable)
+
+	if httpserver != nil {
+		err := httpserver.Shutdown(context)
+		if err != nil {
+			logs.Warning("mcp http server shutdown failed, %s", err.Error())
+		}
+	}
+	if !WaitTimeout(&s.WaitGroup, SHUTDOWN_TIMEOUT) {
+		logs.Warning("mcp server tasks not stopped within %s", SHUTDOWN_TIMEOUT)
 	}
-	s.Wait()
 }
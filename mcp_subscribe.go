@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+var SUBSCRIBE_NOTIFY_INTERVAL = 500 * time.Millisecond
+
+type ResourceSubscriber struct {
+	sync.Mutex
+	sync.WaitGroup
+
+	server   *server.MCPServer
+	allowed  func(ctx context.Context, path string) bool
+	sessions map[string]map[string]string // session id -> uri -> path
+	pending  map[string]map[string]bool   // session id -> uri
+	changes  chan *FileNotify
+}
+
+func NewResourceSubscriber(s *server.MCPServer, allowed func(ctx context.Context, path string) bool) *ResourceSubscriber {
+	r := &ResourceSubscriber{
+		server:   s,
+		allowed:  allowed,
+		sessions: make(map[string]map[string]string),
+		pending:  make(map[string]map[string]bool),
+		changes:  make(chan *FileNotify, 1024),
+	}
+	r.Add(1)
+	go r.notifyTask()
+	return r
+}
+
+// Hooks checks the subscriptions before they are acknowledged, and records
+// the acknowledged ones. The library takes no subscribe handler, a denied or
+// invalid uri is cleared before the request is handled so that it is refused
+// with an invalid params error.
+func (r *ResourceSubscriber) Hooks(hooks *server.Hooks) {
+	hooks.AddBeforeSubscribe(func(ctx context.Context, id any, message *mcp.SubscribeRequest) {
+		err := r.check(ctx, message.Params.URI)
+		if err != nil {
+			logs.Warning("mcp subscribe %s refused, %s", message.Params.URI, err.Error())
+			message.Params.URI = ""
+		}
+	})
+	hooks.AddAfterSubscribe(func(ctx context.Context, id any, message *mcp.SubscribeRequest, result *mcp.EmptyResult) {
+		session := server.ClientSessionFromContext(ctx)
+		if session == nil {
+			return
+		}
+		r.Subscribe(ctx, session.SessionID(), message.Params.URI)
+	})
+	hooks.AddAfterUnsubscribe(func(ctx context.Context, id any, message *mcp.UnsubscribeRequest, result *mcp.EmptyResult) {
+		session := server.ClientSessionFromContext(ctx)
+		if session == nil {
+			return
+		}
+		r.Unsubscribe(session.SessionID(), message.Params.URI)
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		r.Lock()
+		defer r.Unlock()
+		delete(r.sessions, session.SessionID())
+		delete(r.pending, session.SessionID())
+	})
+}
+
+// check returns why uri can not be subscribed by the session of ctx.
+func (r *ResourceSubscriber) check(ctx context.Context, uri string) error {
+	if uri == "" {
+		return fmt.Errorf("uri is required")
+	}
+	path, err := FileURIPath(uri)
+	if err != nil {
+		return err
+	}
+	if !r.allowed(ctx, path) {
+		return fmt.Errorf("path %s access denied", path)
+	}
+	return nil
+}
+
+// Subscribe records the subscription of a path the session may read, the
+// request was checked before it was acknowledged.
+func (r *ResourceSubscriber) Subscribe(ctx context.Context, sessionID string, uri string) {
+	path, err := FileURIPath(uri)
+	if err != nil {
+		logs.Warning("mcp subscribe %s failed, %s", uri, err.Error())
+		return
+	}
+	if !r.allowed(ctx, path) {
+		logs.Warning("mcp session %s subscribe %s access denied", sessionID, path)
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	subs, ok := r.sessions[sessionID]
+	if !ok {
+		subs = make(map[string]string)
+		r.sessions[sessionID] = subs
+	}
+	subs[uri] = path
+
+	logs.Info("mcp session %s subscribe %s", sessionID, path)
+}
+
+func (r *ResourceSubscriber) Unsubscribe(sessionID string, uri string) {
+	r.Lock()
+	defer r.Unlock()
+
+	if subs, ok := r.sessions[sessionID]; ok {
+		delete(subs, uri)
+		if len(subs) == 0 {
+			delete(r.sessions, sessionID)
+		}
+	}
+
+	logs.Info("mcp session %s unsubscribe %s", sessionID, uri)
+}
+
+// FileChanged is called by the sql notify task after a change was applied,
+// it never blocks the caller.
+func (r *ResourceSubscriber) FileChanged(notify *FileNotify) {
+	select {
+	case r.changes <- notify:
+	default:
+		logs.Warning("mcp subscribe change queue full, drop %s", notify.File.Path)
+	}
+}
+
+// match marks the subscriptions the change falls into, a rescan marks the
+// subscriptions below its root as well.
+func (r *ResourceSubscriber) match(notify *FileNotify) {
+	r.Lock()
+	defer r.Unlock()
+
+	path := notify.File.Path
+	rescan := notify.Event == FILE_RESCAN
+
+	for sessionID, subs := range r.sessions {
+		for uri, subPath := range subs {
+			if !PathWithin(path, subPath) && !(rescan && (path == "" || PathWithin(subPath, path))) {
+				continue
+			}
+			pending, ok := r.pending[sessionID]
+			if !ok {
+				pending = make(map[string]bool)
+				r.pending[sessionID] = pending
+			}
+			pending[uri] = true
+		}
+	}
+}
+
+func (r *ResourceSubscriber) flush() {
+	r.Lock()
+	pending := r.pending
+	r.pending = make(map[string]map[string]bool)
+	r.Unlock()
+
+	for sessionID, uris := range pending {
+		for uri := range uris {
+			err := r.server.SendNotificationToSpecificClient(sessionID,
+				mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri})
+			if err != nil {
+				logs.Warning("mcp notify session %s resource %s updated failed, %s", sessionID, uri, err.Error())
+			}
+		}
+	}
+}
+
+func (r *ResourceSubscriber) notifyTask() {
+	defer r.Done()
+
+	ticker := time.NewTicker(SUBSCRIBE_NOTIFY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case notify, ok := <-r.changes:
+			if !ok {
+				r.flush()
+				return
+			}
+			r.match(notify)
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+func (r *ResourceSubscriber) Close() {
+	close(r.changes)
+	r.Wait()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var USAGE_LIMIT = 20
+
+func (s *MCPServer) usageToolInit() {
+	usageTool := mcp.NewTool(
+		"disk_usage",
+		mcp.WithDescription("Show what is using the disk under a directory: its total size and file count, "+
+			"the largest directories and files below it and the size by file extension. "+
+			"Answered from the index without walking the disk."),
+		mcp.WithString("path",
+			mcp.Description("The directory to analyse, empty for all indexed roots"),
+		),
+		mcp.WithNumber("limit",
+			mcp.DefaultNumber(float64(USAGE_LIMIT)),
+			mcp.Description("The number of directories, files and extensions to return"),
+		),
+		mcp.WithNumber("depth",
+			mcp.DefaultNumber(1),
+			mcp.Description("Only list directories at most this many levels below the path, 0 for any level"),
+		),
+	)
+
+	s.server.AddTool(usageTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		defer func() {
+			if err := recover(); err != nil {
+				logs.Error("serve http panic: %v", err)
+			}
+		}()
+
+		scopes := s.roots.Get(ctx)
+		path := request.GetString("path", "")
+		if path != "" {
+			path = filepath.Clean(path)
+			if !s.allowed(ctx, path) {
+				return nil, fmt.Errorf("path %s access denied", path)
+			}
+			scopes = []string{path}
+		} else if len(scopes) == 0 {
+			for _, v := range s.Config().SearchDrives {
+				if v.Enable {
+					scopes = append(scopes, v.Name)
+				}
+			}
+		}
+
+		limit := request.GetFloat("limit", float64(USAGE_LIMIT))
+		if limit <= 0.0 {
+			limit = float64(USAGE_LIMIT)
+		}
+
+		logs.Info("mcp server disk usage %v", scopes)
+
+		usage, err := s.index.DiskUsage(int(limit), int(request.GetFloat("depth", 1)), scopes...)
+		if err != nil {
+			logs.Error("mcp server disk usage failed, %s", err.Error())
+			return nil, err
+		}
+
+		return ResultToJSON(usage)
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var PROGRESS_INTERVAL = 200 * time.Millisecond
+var PROGRESS_LOG_INTERVAL = 5 * time.Second
+
+// Progress receives the progress of a long running operation, total is 0
+// when it is unknown.
+type Progress interface {
+	Update(current, total int64, message string)
+}
+
+type ProgressFunc func(current, total int64, message string)
+
+func (f ProgressFunc) Update(current, total int64, message string) {
+	f(current, total, message)
+}
+
+// GUIProgress shows the progress message in the status bar.
+var GUIProgress = ProgressFunc(func(current, total int64, message string) {
+	WorkingUpdate(message)
+})
+
+func LogProgress(name string) Progress {
+	var last time.Time
+	return ProgressFunc(func(current, total int64, message string) {
+		if time.Since(last) < PROGRESS_LOG_INTERVAL {
+			return
+		}
+		last = time.Now()
+		logs.Info("%s progress %d/%d %s", name, current, total, message)
+	})
+}
+
+type ProgressState struct {
+	Name       string    `json:"name"`
+	Current    int64     `json:"current"`
+	Total      int64     `json:"total"`
+	Message    string    `json:"message"`
+	StartTime  time.Time `json:"start_time"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// Operation fans the progress of one operation out to its sinks, throttled
+// to PROGRESS_INTERVAL, and publishes it on the progress board.
+type Operation struct {
+	sync.Mutex
+
+	id    int
+	log   Progress
+	sinks []Progress
+	last  time.Time
+	state ProgressState
+}
+
+type ProgressBoard struct {
+	sync.Mutex
+
+	seq        int
+	operations map[int]*Operation
+}
+
+var progressBoard = &ProgressBoard{operations: make(map[int]*Operation)}
+
+func StartProgress(name string, sinks ...Progress) *Operation {
+	op := &Operation{
+		log:   LogProgress(name),
+		sinks: make([]Progress, 0),
+		state: ProgressState{Name: name, StartTime: time.Now(), UpdateTime: time.Now()},
+	}
+	for _, v := range sinks {
+		if v != nil {
+			op.sinks = append(op.sinks, v)
+		}
+	}
+
+	progressBoard.Lock()
+	progressBoard.seq++
+	op.id = progressBoard.seq
+	progressBoard.operations[op.id] = op
+	progressBoard.Unlock()
+
+	logs.Info("%s start", name)
+	return op
+}
+
+func (o *Operation) Update(current, total int64, message string) {
+	o.Lock()
+	o.state.Current, o.state.Total, o.state.Message = current, total, message
+	o.state.UpdateTime = time.Now()
+	if time.Since(o.last) < PROGRESS_INTERVAL {
+		o.Unlock()
+		return
+	}
+	o.last = time.Now()
+	o.Unlock()
+
+	o.log.Update(current, total, message)
+	for _, v := range o.sinks {
+		v.Update(current, total, message)
+	}
+}
+
+func (o *Operation) Done(message string) {
+	o.Lock()
+	current, total := o.state.Current, o.state.Total
+	name := o.state.Name
+	o.Unlock()
+
+	if total > 0 {
+		current = total
+	}
+	for _, v := range o.sinks {
+		v.Update(current, total, message)
+	}
+
+	progressBoard.Lock()
+	delete(progressBoard.operations, o.id)
+	progressBoard.Unlock()
+
+	logs.Info("%s done, %s", name, message)
+}
+
+func ProgressList() []ProgressState {
+	progressBoard.Lock()
+	defer progressBoard.Unlock()
+
+	output := make([]ProgressState, 0)
+	for _, op := range progressBoard.operations {
+		op.Lock()
+		output = append(output, op.state)
+		op.Unlock()
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].StartTime.Before(output[j].StartTime)
+	})
+	return output
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var PROMPT_FILE = "prompts.json"
+
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+// PromptConfig is a mcp prompt, the template is rendered with text/template,
+// the arguments are available by name, plus {{.roots}} the roots of the
+// session, {{.tools}} and the {{uri .path}} function converting a path to a
+// file:/// resource uri.
+type PromptConfig struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Arguments   []PromptArgument `json:"arguments"`
+	Template    string           `json:"template"`
+}
+
+var promptDefault = []PromptConfig{
+	{
+		Name:        "find_recent_documents",
+		Description: "Find recently modified documents about a topic",
+		Arguments: []PromptArgument{
+			{Name: "topic", Description: "The topic or keyword of the documents", Required: true},
+			{Name: "days", Description: "Only documents modified within this many days, default 7"},
+		},
+		Template: "Find documents about \"{{.topic}}\" that were modified in the last {{if .days}}{{.days}}{{else}}7{{end}} days.\n" +
+			"Use the file_query tool with category \"document\", order \"recent\" and modified_after set to " +
+			"{{if .days}}{{.days}} days written as a duration in hours, such as 168h for 7 days{{else}}168h{{end}}. " +
+			"Put keywords or GLOB patterns derived from the topic in filename, for example \"*{{.topic}}*\".\n" +
+			"Only these roots are searchable: {{.roots}}.\n" +
+			"List the matches newest first with their path, size and modification time.\n" +
+			"Available tools: {{.tools}}.",
+	},
+	{
+		Name:        "summarize_folder",
+		Description: "Summarize the content of a folder",
+		Arguments: []PromptArgument{
+			{Name: "path", Description: "The folder path to summarize", Required: true},
+		},
+		Template: "Summarize the folder \"{{.path}}\".\n" +
+			"Read the resource {{uri .path}} to list its entries, and use file_query to look deeper into sub folders.\n" +
+			"Report the number of files and folders, the main file types, the largest files and the most recently modified files, " +
+			"then describe what the folder appears to be used for.\n" +
+			"Only these roots are accessible: {{.roots}}.\n" +
+			"Available tools: {{.tools}}.",
+	},
+	{
+		Name:        "find_large_files",
+		Description: "Find large files that could be cleaned up",
+		Arguments: []PromptArgument{
+			{Name: "path", Description: "Limit the search to this folder, default all accessible roots"},
+			{Name: "min_size", Description: "The minimum file size, default 100MB"},
+		},
+		Template: "Find files larger than {{if .min_size}}{{.min_size}}{{else}}100MB{{end}} " +
+			"under {{if .path}}\"{{.path}}\"{{else}}the accessible roots ({{.roots}}){{end}} that could be cleaned up.\n" +
+			"Start with the disk_usage tool{{if .path}} on \"{{.path}}\"{{end}} to see the largest directories, files and extensions. " +
+			"Then use the file_query tool with order \"largest\" and min_size in bytes " +
+			"({{if .min_size}}{{.min_size}} converted to bytes{{else}}104857600{{end}}) to list the large files.\n" +
+			"Group the results by type, show the total size of each group and point out obvious candidates for deletion, " +
+			"but do not delete anything.\n" +
+			"Available tools: {{.tools}}.",
+	},
+}
+
+// promptRetired holds the templates of former defaults by name, a prompt
+// file entry still equal to one of them is no override.
+var promptRetired = map[string][]string{
+	"find_recent_documents": {
+		"Find documents about \"{{.topic}}\" that were modified in the last {{if .days}}{{.days}}{{else}}7{{end}} days.\n" +
+			"Use the file_query tool with keywords or GLOB patterns derived from the topic, " +
+			"for example \"*{{.topic}}*\" combined with document extensions such as .doc, .docx, .pdf, .txt and .md.\n" +
+			"Only the indexed roots are searchable: {{.roots}}.\n" +
+			"Sort the matches by modification time and list the newest first with their path, size and modification time.\n" +
+			"Available tools: {{.tools}}.",
+	},
+	"summarize_folder": {
+		"Summarize the folder \"{{.path}}\".\n" +
+			"Read the resource {{uri .path}} to list its entries, and use file_query to look deeper into sub folders.\n" +
+			"Report the number of files and folders, the main file types, the largest files and the most recently modified files, " +
+			"then describe what the folder appears to be used for.\n" +
+			"Only the indexed roots are accessible: {{.roots}}.\n" +
+			"Available tools: {{.tools}}.",
+	},
+	"find_large_files": {
+		"Find files larger than {{if .min_size}}{{.min_size}}{{else}}100MB{{end}} " +
+			"under {{if .path}}\"{{.path}}\"{{else}}the indexed roots ({{.roots}}){{end}} that could be cleaned up.\n" +
+			"Use the file_query tool with GLOB patterns for typical large files such as *.iso, *.zip, *.mp4, *.log and *.tmp, " +
+			"then filter the results by size.\n" +
+			"Group the results by type, show the total size of each group and point out obvious candidates for deletion, " +
+			"but do not delete anything.\n" +
+			"Available tools: {{.tools}}.",
+	},
+}
+
+// promptOverridden reports whether prompt differs from the built-in default
+// of its name, current or former.
+func promptOverridden(prompt PromptConfig) bool {
+	if slices.Contains(promptRetired[prompt.Name], prompt.Template) {
+		return false
+	}
+	for _, v := range promptDefault {
+		if v.Name == prompt.Name {
+			return !reflect.DeepEqual(v, prompt)
+		}
+	}
+	return true
+}
+
+// promptMerge returns the defaults with the overrides of the same name
+// replacing them, followed by the overrides of new names.
+func promptMerge(defaults []PromptConfig, overrides []PromptConfig) []PromptConfig {
+	output := slices.Clone(defaults)
+	for _, prompt := range overrides {
+		index := slices.IndexFunc(output, func(v PromptConfig) bool { return v.Name == prompt.Name })
+		if index < 0 {
+			output = append(output, prompt)
+		} else {
+			output[index] = prompt
+		}
+	}
+	return output
+}
+
+func promptSave(path string, prompts []PromptConfig) {
+	value, err := json.MarshalIndent(prompts, "", "\t")
+	if err != nil {
+		logs.Error("json marshal prompt fail, %s", err.Error())
+		return
+	}
+	err = os.WriteFile(path, value, 0664)
+	if err != nil {
+		logs.Error("write prompt file fail, %s", err.Error())
+	}
+}
+
+// PromptsLoad returns the built-in prompts merged with the overrides of the
+// prompt file, which holds the overrides only. Entries equal to a built-in
+// default, written by former versions, are dropped from the file so that the
+// updated defaults apply.
+func PromptsLoad() []PromptConfig {
+	path := filepath.Join(ConfigDirGet(), PROMPT_FILE)
+
+	value, err := os.ReadFile(path)
+	if err != nil {
+		logs.Info("prompt file not exist, create an empty one")
+		promptSave(path, make([]PromptConfig, 0))
+		return promptDefault
+	}
+
+	prompts := make([]PromptConfig, 0)
+	err = json.Unmarshal(value, &prompts)
+	if err != nil {
+		logs.Error("json unmarshal prompt file fail, %s", err.Error())
+		return promptDefault
+	}
+
+	overrides := slices.DeleteFunc(slices.Clone(prompts), func(v PromptConfig) bool { return !promptOverridden(v) })
+	if len(overrides) != len(prompts) {
+		logs.Info("prompt file holds %d defaults, keep %d overrides", len(prompts)-len(overrides), len(overrides))
+		promptSave(path, overrides)
+	}
+
+	return promptMerge(promptDefault, overrides)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPromptMerge(t *testing.T) {
+	defaults := []PromptConfig{{Name: "a", Template: "a"}, {Name: "b", Template: "b"}}
+	overrides := []PromptConfig{{Name: "c", Template: "c"}, {Name: "b", Template: "b2"}}
+
+	got := promptMerge(defaults, overrides)
+	want := []PromptConfig{{Name: "a", Template: "a"}, {Name: "b", Template: "b2"}, {Name: "c", Template: "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("promptMerge = %v, want %v", got, want)
+	}
+	if defaults[1].Template != "b" {
+		t.Errorf("promptMerge changed the defaults")
+	}
+}
+
+func TestPromptOverridden(t *testing.T) {
+	for _, prompt := range promptDefault {
+		if promptOverridden(prompt) {
+			t.Errorf("default %s is an override", prompt.Name)
+		}
+		retired := prompt
+		for _, template := range promptRetired[prompt.Name] {
+			retired.Template = template
+			if promptOverridden(retired) {
+				t.Errorf("former default %s is an override", prompt.Name)
+			}
+		}
+		changed := prompt
+		changed.Template += "\nBe brief."
+		if !promptOverridden(changed) {
+			t.Errorf("changed %s is no override", prompt.Name)
+		}
+	}
+	if !promptOverridden(PromptConfig{Name: "mine", Template: "{{.roots}}"}) {
+		t.Errorf("new prompt is no override")
+	}
+}
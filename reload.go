@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// Reload applies config to the running server without a restart. Watchers
+// and scans of added or removed drives are started or stopped, filter
+// changes prune or rescan the affected roots and listener changes rebind
+// the mcp http server only, keeping the index and the jobs.
+func (s *Server) Reload(config Config) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if s.ctx.Err() != nil {
+		return fmt.Errorf("server is shutting down")
+	}
+
+	old := s.Config()
+
+	s.configLock.Lock()
+	s.config = config
+	s.configLock.Unlock()
+
+	if old.JournalDays != config.JournalDays || old.JournalRows != config.JournalRows {
+		s.sql.JournalRetention(config.JournalDays, config.JournalRows)
+	}
+
+	if !s.sql.ReadOnly() {
+		kept := s.reloadDrives(old, config)
+		s.reloadFilters(old, config, kept)
+	}
+
+	err := s.reloadMCP(old, config)
+	if err != nil {
+		// the mcp server was kept as it was, so are its settings
+		s.configLock.Lock()
+		s.config.McpEnable, s.config.McpListen, s.config.McpPort = old.McpEnable, old.McpListen, old.McpPort
+		s.config.McpSSE, s.config.McpStreamable = old.McpSSE, old.McpStreamable
+		s.configLock.Unlock()
+
+		logs.Error("config reload failed, %s", err.Error())
+		return err
+	}
+
+	logs.Info("config reloaded")
+	return nil
+}
+
+func enabledDrives(config Config) []string {
+	output := make([]string, 0)
+	for _, v := range config.SearchDrives {
+		if v.Enable {
+			output = append(output, v.Name)
+		}
+	}
+	return output
+}
+
+// reloadDrives stops the watchers and jobs of removed drives and drops them
+// from the index, and watches and scans the added drives. It returns the
+// drives enabled before and after.
+func (s *Server) reloadDrives(old Config, config Config) []string {
+	before, after := enabledDrives(old), enabledDrives(config)
+
+	kept := make([]string, 0)
+	for _, drive := range after {
+		if slices.Contains(before, drive) {
+			kept = append(kept, drive)
+		}
+	}
+
+	if s.file != nil {
+		s.file.SetConfig(config)
+		if old.CacheLength != config.CacheLength {
+			for _, drive := range s.file.Watching() {
+				s.file.Unwatch(drive)
+			}
+		}
+	}
+
+	for _, drive := range before {
+		if slices.Contains(after, drive) {
+			continue
+		}
+		if s.file != nil {
+			s.file.Unwatch(drive)
+		}
+		s.dropDrive(drive)
+	}
+
+	added := make([]string, 0)
+	for _, drive := range after {
+		if s.file != nil {
+			err := s.file.Watch(drive)
+			if err != nil {
+				logs.Error("watch drive %s failed, %s", drive, err.Error())
+			}
+		}
+		if !slices.Contains(before, drive) {
+			added = append(added, drive)
+		}
+	}
+	s.lostRescan(added...)
+
+	return kept
+}
+
+// dropDrive cancels the jobs under drive and removes it from the index once
+// they stopped.
+func (s *Server) dropDrive(drive string) {
+	cancelled := s.jobs.CancelTarget(drive)
+
+	_, err := s.jobs.Start(s.ctx, "drop", drive, func(ctx context.Context, progress Progress) (string, error) {
+		for _, job := range cancelled {
+			err := job.Wait(ctx)
+			if err != nil {
+				return "", err
+			}
+		}
+		err := s.sql.DriveDrop(drive)
+		if err != nil {
+			return "", err
+		}
+		ShowRowCount(s.sql)
+		return "dropped", nil
+	})
+	if err != nil {
+		logs.Error("drop drive %s failed, %s", drive, err.Error())
+	}
+}
+
+// reloadFilters prunes the drives when the filters exclude more, and
+// rescans the folders no longer excluded, or the drives when the change
+// can not be narrowed to a folder.
+func (s *Server) reloadFilters(old Config, config Config, kept []string) {
+	prune := (config.FilterHide && !old.FilterHide) || (config.FilterSystem && !old.FilterSystem)
+	full := (!config.FilterHide && old.FilterHide) || (!config.FilterSystem && old.FilterSystem)
+
+	// the metadata of the indexed entries is filled by a rescan
+	full = full || (config.IndexMeta && !old.IndexMeta)
+
+	for _, v := range config.FilterFolder {
+		if !slices.Contains(old.FilterFolder, v) {
+			prune = true
+		}
+	}
+
+	// a regexp can not be narrowed to a folder, removing one rescans all
+	for _, v := range config.FilterRegexp {
+		if !slices.Contains(old.FilterRegexp, v) {
+			prune = true
+		}
+	}
+	for _, v := range old.FilterRegexp {
+		if !slices.Contains(config.FilterRegexp, v) {
+			full = true
+		}
+	}
+
+	rescan := make([]string, 0)
+	for _, v := range old.FilterFolder {
+		if slices.Contains(config.FilterFolder, v) {
+			continue
+		}
+		if filepath.IsAbs(v) && config.CheckAccess(v) {
+			rescan = append(rescan, filepath.Clean(v))
+		} else {
+			full = true
+		}
+	}
+
+	if full {
+		// the rescan also removes the entries excluded now
+		s.lostRescan(kept...)
+		return
+	}
+
+	s.lostRescan(rescan...)
+
+	if !prune {
+		return
+	}
+	for _, drive := range kept {
+		_, err := s.jobs.Start(s.ctx, "prune", drive, func(ctx context.Context, progress Progress) (string, error) {
+			removed, err := PruneScan(ctx, s.sql, s.Config(), drive)
+			ShowRowCount(s.sql)
+			return fmt.Sprintf("%d removed", removed), err
+		})
+		if err != nil {
+			logs.Error("prune drive %s failed, %s", drive, err.Error())
+		}
+	}
+}
+
+// reloadMCP starts, stops or rebinds the mcp http server when its settings
+// changed, sessions of other transports are kept. The running server is
+// kept when the new one fails.
+func (s *Server) reloadMCP(old Config, config Config) error {
+	rebind := old.McpListen != config.McpListen || old.McpPort != config.McpPort ||
+		old.McpSSE != config.McpSSE || old.McpStreamable != config.McpStreamable
+
+	s.mcpLock.Lock()
+	defer s.mcpLock.Unlock()
+
+	switch {
+	case !config.McpEnable && s.mcp != nil:
+		s.mcp.Shutdown()
+		s.mcp = nil
+	case config.McpEnable && s.mcp == nil:
+		mcp := NewMCPServer(s)
+		err := mcp.Startup(config.McpListen, config.McpPort, config.McpSSE, config.McpStreamable)
+		if err != nil {
+			mcp.Shutdown()
+			return fmt.Errorf("mcp server startup failed, %s", err.Error())
+		}
+		s.mcp = mcp
+	case config.McpEnable && rebind:
+		err := s.mcp.Rebind(config.McpListen, config.McpPort, config.McpSSE, config.McpStreamable)
+		if err != nil {
+			return fmt.Errorf("mcp server rebind failed, %s", err.Error())
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"time"
@@ -8,16 +9,27 @@ import (
 	"github.com/astaxie/beego/logs"
 )
 
-func driveScan(s *SQLiteDB, cfg Config, drive string, shutdown *bool) error {
-	startup := time.Now()
+var SCAN_RECONCILE_PAGE = 1000
 
-	err := filepath.Walk(drive, func(path string, info os.FileInfo, err error) error {
+// fileMetaScan reads the extended metadata when it is enabled, it costs a
+// handle and a security query per entry.
+func fileMetaScan(cfg Config, path string, info os.FileInfo) FileMeta {
+	if !cfg.IndexMeta {
+		return FileMeta{}
+	}
+	return FileMetaGet(path, info)
+}
+
+func walkScan(ctx context.Context, cfg Config, root string, drive string, progress Progress, write func(FileInfo)) (int, error) {
+	count := 0
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
 
-		if *shutdown {
-			logs.Info("full drive scan cancel")
+		if ctx.Err() != nil {
+			logs.Info("drive scan %s cancel", root)
 			return filepath.SkipAll
 		}
 
@@ -26,7 +38,7 @@ func driveScan(s *SQLiteDB, cfg Config, drive string, shutdown *bool) error {
 				logs.Info("skip folder %s", path)
 				return filepath.SkipDir
 			}
-			s.Write(FileInfo{
+			write(FileInfo{
 				Name:    filepath.Base(path),
 				IsDir:   1,
 				Path:    path,
@@ -34,9 +46,10 @@ func driveScan(s *SQLiteDB, cfg Config, drive string, shutdown *bool) error {
 				Drive:   drive,
 				ModTime: info.ModTime(),
 				Size:    0,
+				Meta:    fileMetaScan(cfg, path, info),
 			})
 		} else {
-			s.Write(FileInfo{
+			write(FileInfo{
 				Name:    filepath.Base(path),
 				IsDir:   0,
 				Path:    path,
@@ -44,29 +57,39 @@ func driveScan(s *SQLiteDB, cfg Config, drive string, shutdown *bool) error {
 				Drive:   drive,
 				ModTime: info.ModTime(),
 				Size:    info.Size(),
+				Meta:    fileMetaScan(cfg, path, info),
 			})
 		}
+		count++
 
-		temp := time.Now()
-
-		if temp.Sub(startup).Milliseconds() > 200 {
-			WorkingUpdate(path)
-			startup = temp
-		}
+		progress.Update(int64(count), 0, path)
 
 		return nil
 	})
+	return count, err
+}
+
+func driveScan(ctx context.Context, s *SQLiteDB, cfg Config, drive string, progress Progress) error {
+	_, err := walkScan(ctx, cfg, drive, drive, progress, s.Write)
+	s.Rescanned(drive)
+	if err == nil && ctx.Err() == nil {
+		s.MetaSet("last_scan:"+drive, time.Now().Format(time.RFC3339))
+		err = s.UsageRebuild(drive)
+	}
 	return err
 }
 
-func DriveFullScan(s *SQLiteDB, cfg Config, shutdown *bool) {
+func DriveFullScan(ctx context.Context, s *SQLiteDB, cfg Config, progress Progress) {
 	for _, drive := range cfg.SearchDrives {
-		if !drive.Enable && !*shutdown {
+		if ctx.Err() != nil {
+			return
+		}
+		if !drive.Enable {
 			continue
 		}
 
 		logs.Info("full drive scan %s start", drive.Name)
-		err := driveScan(s, cfg, drive.Name, shutdown)
+		err := driveScan(ctx, s, cfg, drive.Name, progress)
 		if err != nil {
 			logs.Error("full drive scan %s error: %s", drive.Name, err.Error())
 		} else {
@@ -74,3 +97,65 @@ func DriveFullScan(s *SQLiteDB, cfg Config, shutdown *bool) {
 		}
 	}
 }
+
+// SubtreeScan brings the index of root up to date without a reset, new and
+// changed entries are written, entries no longer on disk are removed.
+func SubtreeScan(ctx context.Context, s *SQLiteDB, cfg Config, root string, progress Progress) (int, error) {
+	drive := root
+	if len(root) > 3 {
+		drive = root[:3]
+	}
+
+	// the entries are written without the journal
+	defer s.Rescanned(root)
+
+	count, err := walkScan(ctx, cfg, root, drive, progress, s.Upsert)
+	if err != nil {
+		return count, err
+	}
+
+	removed := reconcile(ctx, s, cfg, root)
+	logs.Info("subtree scan %s, %d entries, %d removed", root, count, removed)
+
+	if ctx.Err() == nil {
+		s.MetaSet("last_scan:"+root, time.Now().Format(time.RFC3339))
+		return count, s.UsageRebuild(root)
+	}
+	return count, nil
+}
+
+// PruneScan removes the entries under root excluded by the filters of cfg
+// or gone from disk, without walking the disk.
+func PruneScan(ctx context.Context, s *SQLiteDB, cfg Config, root string) (int, error) {
+	removed := reconcile(ctx, s, cfg, root)
+	logs.Info("prune scan %s, %d removed", root, removed)
+	if removed > 0 {
+		s.Rescanned(root)
+	}
+
+	if ctx.Err() == nil {
+		return removed, s.UsageRebuild(root)
+	}
+	return removed, nil
+}
+
+func reconcile(ctx context.Context, s *SQLiteDB, cfg Config, root string) int {
+	removed := 0
+	after := ""
+
+	for ctx.Err() == nil {
+		paths, err := s.PathPage(root, after, SCAN_RECONCILE_PAGE)
+		if err != nil || len(paths) == 0 {
+			break
+		}
+		for _, path := range paths {
+			_, err := os.Lstat(path)
+			if os.IsNotExist(err) || cfg.CheckFolder(path) {
+				s.Delete(path)
+				removed++
+			}
+		}
+		after = paths[len(paths)-1]
+	}
+	return removed
+}
@@ -1,19 +1,40 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 
 	"github.com/astaxie/beego/logs"
 )
 
-type Server struct {
-	shutdown bool
+var SHUTDOWN_TIMEOUT = 5 * time.Second
 
-	config Config
-	sql    *SQLiteDB
-	mcp    *MCPServer
-	file   *FileEvent
+type Server struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	shutdown sync.Once
+	tasks    sync.WaitGroup
+
+	reloadLock sync.Mutex
+	configLock sync.RWMutex
+	config     Config
+
+	lock *IndexLock
+	sql  *SQLiteDB
+	file *FileEvent
+	jobs *JobManager
+
+	mcpLock sync.Mutex
+	mcp     *MCPServer
+
+	lostLock   sync.Mutex
+	lost       map[string][]string // roots to reconcile by drive
+	lostActive map[string]bool
 }
 
 func ShowRowCount(sql *SQLiteDB) {
@@ -27,58 +48,121 @@ func ShowRowCount(sql *SQLiteDB) {
 }
 
 func NewServer(config Config) (*Server, error) {
-	sql, err := NewSQLiteDB()
+	lock, err := IndexLockAcquire()
+	if err != nil {
+		logs.Warning("index lock failed, open read-only, %s", err.Error())
+	}
+
+	sql, err := NewSQLiteDB(lock == nil)
 	if err != nil {
 		logs.Error("sqlite db init failed %s", err.Error())
+		if lock != nil {
+			lock.Release()
+		}
 		return nil, err
 	}
 
-	var mcp *MCPServer
+	ctx, cancel := context.WithCancel(context.Background())
+
+	srv := &Server{ctx: ctx, cancel: cancel, lock: lock, sql: sql, config: config,
+		jobs: NewJobManager(ctx, sql), lost: make(map[string][]string), lostActive: make(map[string]bool)}
+
+	sql.JournalRetention(config.JournalDays, config.JournalRows)
+
+	if !sql.ReadOnly() {
+		srv.file, err = NewFileEvent(ctx, sql, config, srv.lostRescan)
+		if err != nil {
+			logs.Error("file event startup failed, %s", err.Error())
+		}
+		srv.UsageInit()
+		srv.dirtyRescan()
+		srv.ClassifyInit()
+	}
+
 	if config.McpEnable {
-		mcp = NewMCPServer(sql)
-		err = mcp.Startup(config.McpListen, config.McpPort)
+		srv.mcp = NewMCPServer(srv)
+		err = srv.mcp.Startup(config.McpListen, config.McpPort, config.McpSSE, config.McpStreamable)
 		if err != nil {
 			logs.Error("mcp server startup failed, %s", err.Error())
+			srv.Shutdown()
 			return nil, err
 		}
 	}
 
-	file, err := NewFileEvent(sql, config)
-	if err != nil {
-		logs.Error("file event startup failed, %s", err.Error())
-	}
-
 	logs.Info("server init success")
 
 	ShowRowCount(sql)
 
-	return &Server{
-		sql: sql, mcp: mcp, file: file,
-		config: config,
-	}, nil
+	return srv, nil
 }
 
+func (s *Server) Config() Config {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config
+}
+
+// Shutdown stops the watchers, cancels the running jobs and waits for them
+// at most SHUTDOWN_TIMEOUT, then closes the index. It is safe to call while
+// a scan is running and more than once.
 func (s *Server) Shutdown() {
-	s.shutdown = true
-	s.file.Close()
+	s.shutdown.Do(s.close)
+}
+
+func (s *Server) close() {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	s.cancel()
+	s.jobs.CancelAll()
+	if !WaitTimeout(&s.jobs.WaitGroup, SHUTDOWN_TIMEOUT) {
+		logs.Warning("jobs not stopped within %s", SHUTDOWN_TIMEOUT)
+	}
+	if !WaitTimeout(&s.tasks, SHUTDOWN_TIMEOUT) {
+		logs.Warning("background tasks not stopped within %s", SHUTDOWN_TIMEOUT)
+	}
 
+	s.mcpLock.Lock()
 	if s.mcp != nil {
 		s.mcp.Shutdown()
+		s.mcp = nil
 	}
+	s.mcpLock.Unlock()
 
 	s.sql.Close()
+
+	if s.lock != nil {
+		s.lock.Release()
+	}
 	logs.Info("server done")
 }
 
+// RebuidIndex drops the index and scans all drives again as a job, and
+// waits for it to finish.
 func (s *Server) RebuidIndex() {
-	err := s.sql.Reset()
+	if s.sql.ReadOnly() {
+		logs.Error("index rebuild failed, index is read-only")
+		return
+	}
+
+	job, err := s.jobs.Start(s.ctx, "rebuild", "", func(ctx context.Context, progress Progress) (string, error) {
+		err := s.sql.Reset()
+		if err != nil {
+			return "", fmt.Errorf("sql index reset failed, %s", err.Error())
+		}
+		DriveFullScan(ctx, s.sql, s.Config(), progress)
+		ShowRowCount(s.sql)
+		cnt, _ := s.sql.Count()
+		return fmt.Sprintf("%d files", cnt), nil
+	}, GUIProgress)
 	if err != nil {
-		logs.Error("sql index reset failed, %s", err.Error())
+		logs.Error("index rebuild failed, %s", err.Error())
 		return
 	}
-	logs.Info("force scan start")
-	DriveFullScan(s.sql, s.config, &s.shutdown)
-	logs.Info("force scan end")
 
-	ShowRowCount(s.sql)
+	job.Wait(s.ctx)
 }
@@ -127,7 +127,7 @@ func SearchSettingDialog(from walk.Form) {
 	var dlg *walk.Dialog
 	var acceptPB, cancelPB *walk.PushButton
 
-	var ignoreFolderCB, ignoreSystem, monitorCB *walk.CheckBox
+	var ignoreFolderCB, ignoreSystem, monitorCB, metaCB *walk.CheckBox
 	var driveTableView, filterListView *walk.TableView
 
 	driveTable := &DriveTable{
@@ -218,6 +218,16 @@ func SearchSettingDialog(from walk.Form) {
 									config.FileNotify = monitorCB.Checked()
 								},
 							},
+							CheckBox{
+								Alignment:          AlignHNearVCenter,
+								AssignTo:           &metaCB,
+								Text:               "Index File Metadata",
+								Checked:            config.IndexMeta,
+								RightToLeftReading: true,
+								OnCheckedChanged: func() {
+									config.IndexMeta = metaCB.Checked()
+								},
+							},
 						},
 					},
 
@@ -283,6 +293,7 @@ func SearchSettingDialog(from walk.Form) {
 								ErrorBoxAction(dlg, "Save config failed, "+err.Error())
 								return
 							}
+							go ConfigApply(config)
 							dlg.Accept()
 							logs.Info("SearchSettingDialog accept")
 						},
@@ -310,7 +321,7 @@ func McpServerConfigDialog(from walk.Form) {
 	var acceptPB, cancelPB *walk.PushButton
 	var listenBox *walk.ComboBox
 	var portNum *walk.NumberEdit
-	var enableCB *walk.CheckBox
+	var enableCB, sseCB, streamableCB *walk.CheckBox
 
 	interfaces := InterfaceOptions()
 	config := ConfigGet()
@@ -327,8 +338,8 @@ func McpServerConfigDialog(from walk.Form) {
 	_, err := Dialog{
 		AssignTo:      &dlg,
 		Title:         "MCP Server Setting",
-		MinSize:       Size{Width: 400, Height: 200},
-		Size:          Size{Width: 400, Height: 200},
+		MinSize:       Size{Width: 400, Height: 250},
+		Size:          Size{Width: 400, Height: 250},
 		Icon:          ICON_Setting,
 		Font:          DefaultFont(),
 		DefaultButton: &acceptPB,
@@ -374,6 +385,24 @@ func McpServerConfigDialog(from walk.Form) {
 							config.McpEnable = enableCB.Checked()
 						},
 					},
+					HSpacer{},
+					CheckBox{
+						AssignTo: &sseCB,
+						Text:     "SSE Transport (/sse)",
+						Checked:  config.McpSSE,
+						OnCheckedChanged: func() {
+							config.McpSSE = sseCB.Checked()
+						},
+					},
+					HSpacer{},
+					CheckBox{
+						AssignTo: &streamableCB,
+						Text:     "Streamable HTTP Transport (/mcp)",
+						Checked:  config.McpStreamable,
+						OnCheckedChanged: func() {
+							config.McpStreamable = streamableCB.Checked()
+						},
+					},
 				},
 			},
 			VSpacer{},
@@ -385,11 +414,15 @@ func McpServerConfigDialog(from walk.Form) {
 						AssignTo: &acceptPB,
 						Text:     "Accept",
 						OnClicked: func() {
+							if config.McpEnable && !config.McpSSE && !config.McpStreamable {
+								ErrorBoxAction(dlg, "Select at least one transport")
+								return
+							}
 							if err := ConfigSet(config); err != nil {
 								ErrorBoxAction(dlg, "Save config failed, "+err.Error())
 								return
 							}
-							ServerRestart(config)
+							go ConfigApply(config)
 							dlg.Accept()
 							logs.Info("McpServerConfigDialog accept")
 						},
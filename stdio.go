@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// StdioMain serves the mcp tools over stdin/stdout, so that mcp clients can
+// launch this program as a subprocess with a `command` entry.
+func StdioMain() {
+	// stdout carries the json-rpc stream only, anything else goes to stderr
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	FileInit()
+	LogInit()
+	logs.GetBeeLogger().DelLogger(logs.AdapterConsole)
+	ConfigInit()
+
+	config := ConfigGet()
+	config.McpEnable = false
+
+	server, err := NewServer(config)
+	if err != nil {
+		logs.Error("stdio server init failed, %s", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	mcp := NewMCPServer(server)
+
+	go ConfigWatch(ctx, func(config Config) {
+		config.McpEnable = false
+		server.Reload(config)
+	})
+
+	logs.Info("mcp stdio server startup, read-only: %v", server.sql.ReadOnly())
+
+	err = mcp.ServeStdio(ctx, os.Stdin, stdout)
+	if err != nil && err != context.Canceled {
+		logs.Warning("mcp stdio server exit, %s", err.Error())
+	}
+
+	mcp.Shutdown()
+	server.Shutdown()
+}
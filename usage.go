@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+var USAGE_META_KEY = "dir_usage"
+
+type DiskUsage struct {
+	Roots       []UsageEntry `json:"roots"`
+	Directories []UsageEntry `json:"directories"`
+	Files       []UsageEntry `json:"files"`
+	Extensions  []ExtUsage   `json:"extensions"`
+}
+
+// UsageInit computes the directory aggregates of an index built before they
+// were maintained, in the background without touching the disk.
+func (s *Server) UsageInit() {
+	if s.sql.ReadOnly() || s.sql.MetaGet(USAGE_META_KEY) != "" {
+		return
+	}
+
+	drives := make([]string, 0)
+	for _, v := range s.Config().SearchDrives {
+		if v.Enable {
+			drives = append(drives, v.Name)
+		}
+	}
+
+	_, err := s.jobs.Start(s.ctx, "usage", "", func(ctx context.Context, progress Progress) (string, error) {
+		for i, drive := range drives {
+			if ctx.Err() != nil {
+				return "", nil
+			}
+			progress.Update(int64(i), int64(len(drives)), drive)
+			err := s.sql.UsageRebuild(drive)
+			if err != nil {
+				return "", err
+			}
+		}
+		s.sql.MetaSet(USAGE_META_KEY, time.Now().Format(time.RFC3339))
+		return fmt.Sprintf("%d drives", len(drives)), nil
+	})
+	if err != nil {
+		logs.Warning("dir usage init failed, %s", err.Error())
+	}
+}
+
+// DiskUsage reports the size of the scopes, their largest directories and
+// files and the size by extension, from the index only.
+func (s *Server) DiskUsage(limit int, depth int, scopes ...string) (*DiskUsage, error) {
+	usage := &DiskUsage{Roots: make([]UsageEntry, 0)}
+
+	for _, scope := range scopes {
+		usage.Roots = append(usage.Roots, s.sql.UsageGet(scope))
+	}
+
+	var err error
+	usage.Directories, err = s.sql.UsageDirs(limit, depth, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("query directory usage failed, %s", err.Error())
+	}
+	usage.Files, err = s.sql.UsageFiles(limit, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("query file usage failed, %s", err.Error())
+	}
+	usage.Extensions, err = s.sql.UsageExts(limit, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("query extension usage failed, %s", err.Error())
+	}
+	return usage, nil
+}
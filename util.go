@@ -5,6 +5,11 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +24,21 @@ import (
 
 var APPLICATION_VERSION = "0.2.0"
 
+// WaitTimeout waits for wg at most timeout, false when it timed out.
+func WaitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func SaveToFile(name string, body []byte) error {
 	return os.WriteFile(name, body, 0664)
 }
@@ -35,6 +55,31 @@ func CapSignal(proc func()) {
 	}()
 }
 
+// PathWithin reports whether path is root itself or below it, windows paths
+// are compared case-insensitive.
+func PathWithin(path string, root string) bool {
+	path = strings.ToLower(path)
+	root = strings.TrimSuffix(strings.ToLower(root), string(filepath.Separator))
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// PathsOutermost returns the paths not below another one of them, sorted.
+func PathsOutermost(paths []string) []string {
+	paths = slices.Clone(paths)
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.ToLower(paths[i]) < strings.ToLower(paths[j])
+	})
+
+	output := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if len(output) > 0 && PathWithin(path, output[len(output)-1]) {
+			continue
+		}
+		output = append(output, path)
+	}
+	return output
+}
+
 func TimeStampGet(tm time.Time) string {
 	return tm.Format("2006-01-02 15:04:05")
 }
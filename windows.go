@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sort"
@@ -14,6 +15,15 @@ import (
 
 var mainWindow *walk.MainWindow
 var mainServer *Server
+var mainServerLock sync.RWMutex
+var mainServerRestart sync.Mutex // serializes the restarts, held while slow
+
+// ServerGet returns the running server, nil while it failed to start.
+func ServerGet() *Server {
+	mainServerLock.RLock()
+	defer mainServerLock.RUnlock()
+	return mainServer
+}
 
 var WILDCARDS_HELP = `
 The GLOB operator in SQLite uses wildcards for pattern matching.
@@ -81,9 +91,9 @@ func MenuBarInit() []MenuItem {
 					Enabled:  true,
 					OnTriggered: func() {
 						go func() {
-							if mainServer != nil {
+							if server := ServerGet(); server != nil {
 								forceScan.SetEnabled(false)
-								mainServer.RebuidIndex()
+								server.RebuidIndex()
 								forceScan.SetEnabled(true)
 							}
 						}()
@@ -229,15 +239,21 @@ func (m *QueryTable) Sort(col int, order walk.SortOrder) error {
 	return m.SorterBase.Sort(col, order)
 }
 
-func (m *QueryTable) QuerySearch(keyword string) error {
+func (m *QueryTable) QuerySearch(keyword string, group string) error {
 	m.Lock()
 	defer m.Unlock()
 
-	if mainServer == nil {
+	server := ServerGet()
+	if server == nil {
 		return fmt.Errorf("sqlite db init failed")
 	}
 
-	fileInfos, err := mainServer.sql.Query(keyword, 1024)
+	filter := QueryFilter{Keyword: keyword, Limit: 1024}
+	if group != "" {
+		filter.Groups = []string{group}
+	}
+
+	fileInfos, err := server.Find(context.Background(), nil, filter)
 	if err != nil {
 		return err
 	}
@@ -270,6 +286,43 @@ func (m *QueryTable) OpenFile(index int) {
 var queryTableView *walk.TableView
 var queryTableData *QueryTable
 var searchText *walk.LineEdit
+var groupBox *walk.ComboBox
+
+var GROUP_ALL = "All files"
+
+func groupBoxModel(config Config) []string {
+	return append([]string{GROUP_ALL}, config.ExtGroupNames()...)
+}
+
+// groupBoxUpdate refreshes the groups after a config change, keeping the
+// selected group while it exists.
+func groupBoxUpdate(config Config) {
+	selected := groupBox.Text()
+	model := groupBoxModel(config)
+	groupBox.SetModel(model)
+
+	index := 0
+	for i, name := range model {
+		if name == selected {
+			index = i
+		}
+	}
+	groupBox.SetCurrentIndex(index)
+}
+
+func searchRun() {
+	if searchText == nil {
+		return
+	}
+	group := ""
+	if groupBox != nil && groupBox.CurrentIndex() > 0 {
+		group = groupBox.Text()
+	}
+	err := queryTableData.QuerySearch(searchText.Text(), group)
+	if err != nil {
+		StatusUpdate(err.Error())
+	}
+}
 
 func init() {
 	queryTableData = &QueryTable{
@@ -277,17 +330,56 @@ func init() {
 	}
 }
 
+// ServerRestart stops the running server, cancelling its scans, and starts
+// a new one with config. Only the swap of mainServer holds mainServerLock,
+// the GUI sees no server while the old one stops and the new one opens the
+// index.
 func ServerRestart(config Config) {
-	var err error
+	mainServerRestart.Lock()
+	defer mainServerRestart.Unlock()
 
-	if mainServer != nil {
-		mainServer.Shutdown()
+	serverShutdown()
+
+	server, err := NewServer(config)
+	if err != nil {
+		StatusUpdate(err.Error())
+	}
+
+	mainServerLock.Lock()
+	mainServer = server
+	mainServerLock.Unlock()
+}
+
+// serverShutdown takes the running server away and shuts it down outside
+// of mainServerLock.
+func serverShutdown() {
+	mainServerLock.Lock()
+	server := mainServer
+	mainServer = nil
+	mainServerLock.Unlock()
+
+	if server != nil {
+		server.Shutdown()
 	}
+}
 
-	mainServer, err = NewServer(config)
+// ConfigApply applies a changed config to the running server, or starts the
+// server when it is not running.
+func ConfigApply(config Config) {
+	server := ServerGet()
+	if server == nil {
+		ServerRestart(config)
+		return
+	}
+	err := server.Reload(config)
 	if err != nil {
 		StatusUpdate(err.Error())
 	}
+	if mainWindow != nil && groupBox != nil {
+		mainWindow.Synchronize(func() {
+			groupBoxUpdate(config)
+		})
+	}
 }
 
 func MainWindows() {
@@ -299,6 +391,8 @@ func MainWindows() {
 
 	ServerRestart(ConfigGet())
 
+	go ConfigWatch(context.Background(), ConfigApply)
+
 	CapSignal(CloseWindows)
 
 	cnt, err := MainWindow{
@@ -318,12 +412,19 @@ func MainWindows() {
 					LineEdit{
 						AssignTo: &searchText,
 						OnEditingFinished: func() {
-							queryTableData.QuerySearch(searchText.Text())
+							searchRun()
 						},
 						OnTextChanged: func() {
-							queryTableData.QuerySearch(searchText.Text())
+							searchRun()
 						},
 					},
+					ComboBox{
+						AssignTo:              &groupBox,
+						Model:                 groupBoxModel(ConfigGet()),
+						CurrentIndex:          0,
+						MaxSize:               Size{Width: 150},
+						OnCurrentIndexChanged: searchRun,
+					},
 				},
 			},
 			TableView{
@@ -356,10 +457,10 @@ func MainWindows() {
 }
 
 func CloseWindows() {
-	if mainServer != nil {
-		mainServer.Shutdown()
-		mainServer = nil
-	}
+	mainServerRestart.Lock()
+	serverShutdown()
+	mainServerRestart.Unlock()
+
 	if mainWindow != nil {
 		mainWindow.Close()
 		mainWindow = nil